@@ -6,27 +6,87 @@ import axios from "axios";
 const API_URL = import.meta.env.VITE_API_URL || "http://localhost:5000";
 const Login = () => {
   const [form, setForm] = useState({ email: "", password: "" });
+  const [challenge, setChallenge] = useState("");
+  const [code, setCode] = useState("");
   const navigate = useNavigate();
 
   const handleChange = e => {
     setForm({ ...form, [e.target.name]: e.target.value });
   };
 
+  const startSession = token => {
+    localStorage.setItem("token", token);
+    axios.defaults.headers.common["Authorization"] = `Bearer ${token}`;
+    navigate("/tracker");
+  };
+
   const handleSubmit = async e => {
     e.preventDefault();
     if (!form.email || !form.password) return;
+    let res;
     try{
-      const res = await axios.get(
-        `${API_URL}/login`
-      );
+      res = await axios.post(`${API_URL}/login`, {
+        email: form.email,
+        password: form.password,
+      });
     }catch(err){
       console.error("Login error:", err);
       alert("Login failed. Please check your credentials.");
       return; 
     }
-    navigate("/tracker");
+    // Accounts with two-factor authentication get a challenge instead of a
+    // session, answered with a code from their authenticator app.
+    if (res.status === 202 && res.data.two_factor_required) {
+      setChallenge(res.data.two_factor_token);
+      return;
+    }
+    startSession(res.data.token);
+  };
+
+  const handleCode = async e => {
+    e.preventDefault();
+    const value = code.trim();
+    if (!value) return;
+    // Authenticator codes are six digits; anything else is a recovery code.
+    const answer = /^\d{6}$/.test(value) ? { code: value } : { recovery_code: value };
+    let res;
+    try{
+      res = await axios.post(`${API_URL}/login/2fa`, { token: challenge, ...answer });
+    }catch(err){
+      // A challenge can only be answered once, so any failure means
+      // starting over from the password.
+      console.error("Two-factor error:", err);
+      alert(err.response?.data?.code === "invalid_two_factor_code"
+        ? "That code didn't work. Please sign in again."
+        : "Login failed. Please sign in again.");
+      setChallenge("");
+      setCode("");
+      return;
+    }
+    startSession(res.data.token);
   };
 
+  if (challenge) {
+    return (
+      <div className="auth-container">
+        <form className="auth-form" onSubmit={handleCode}>
+          <h2>Two-factor authentication</h2>
+          <input
+            type="text"
+            name="code"
+            placeholder="Code from your authenticator app, or a recovery code"
+            autoComplete="one-time-code"
+            value={code}
+            onChange={e => setCode(e.target.value)}
+            required
+            autoFocus
+          />
+          <button type="submit">Verify</button>
+        </form>
+      </div>
+    );
+  }
+
   return (
     <div className="auth-container">
       <form className="auth-form" onSubmit={handleSubmit}>
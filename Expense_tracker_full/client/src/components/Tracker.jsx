@@ -66,7 +66,7 @@ const Tracker = () =>{
       fetchExpenses(filterCategory);
       fetchCategories();
     } catch (e) {
-      alert("Error: " + (e.response?.data?.error || e.message));
+      alert("Error: " + (e.response?.data?.detail || e.message));
     }
   };
 
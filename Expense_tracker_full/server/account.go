@@ -0,0 +1,267 @@
+package main
+
+import (
+	"gin-app/middleware"
+	"gin-app/problem"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultCurrency = "USD"
+	defaultTimezone = "UTC"
+
+	// reauthWindow is how recently a password-less user must have signed in
+	// with their identity provider to confirm a sensitive change without a
+	// two-factor code.
+	reauthWindow = 10 * time.Minute
+)
+
+var currencyRegex = regexp.MustCompile(`^[A-Z]{3}$`)
+
+type ProfileUpdate struct {
+	DisplayName  *string `json:"display_name"`
+	BaseCurrency *string `json:"base_currency"`
+	Timezone     *string `json:"timezone"`
+}
+
+// PasswordChange sets a new password. CurrentPassword is required once the
+// account has one; a password-less account confirms with Code instead (see
+// confirmPasswordless).
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password"`
+	Code            string `json:"code"`
+	NewPassword     string `json:"new_password"`
+	ConfirmPassword string `json:"confirm_password"`
+}
+
+type PasswordConfirmation struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+func findCurrentUser(c *gin.Context) (SignupUser, bool) {
+	ctx := c.Request.Context()
+	var user SignupUser
+	err := UserDataCollection.FindOne(ctx, bson.M{"_id": currentUserID(c)}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		problem.Abort(c, http.StatusNotFound, "User not found")
+		return user, false
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch user")
+		return user, false
+	}
+	user.HasPassword = user.Password != ""
+	return user, true
+}
+
+// confirmPasswordless re-authenticates a user without a password, who signed
+// up through single sign-on. A current two-factor code confirms, as does a
+// session whose sign-in with the identity provider happened within
+// reauthWindow; otherwise the user has to sign in again.
+func confirmPasswordless(c *gin.Context, user SignupUser, code string) bool {
+	ctx := c.Request.Context()
+	if user.TOTPEnabled && code != "" {
+		ok, err := consumeTOTP(ctx, user, code)
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Failed to verify code")
+			return false
+		}
+		if !ok {
+			problem.Render(c, problem.New(http.StatusUnauthorized, "Invalid two-factor code").WithCode("invalid_two_factor_code"))
+			return false
+		}
+		return true
+	}
+	var session middleware.Session
+	err := SessionCollection.FindOne(ctx, bson.M{"token_hash": middleware.HashToken(middleware.BearerToken(c))}).Decode(&session)
+	if err != nil && err != mongo.ErrNoDocuments {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch session")
+		return false
+	}
+	if err == nil && session.ReauthenticatedAt != nil && time.Since(*session.ReauthenticatedAt) < reauthWindow {
+		return true
+	}
+	problem.Render(c, problem.New(http.StatusUnauthorized, "Sign in with your identity provider again, or give a two-factor code, to confirm this change").
+		WithCode("reauthentication_required"))
+	return false
+}
+
+func getProfile(c *gin.Context) {
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func updateProfile(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req ProfileUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	set := bson.M{"updated_at": time.Now()}
+	if req.DisplayName != nil {
+		name := strings.TrimSpace(*req.DisplayName)
+		if len(name) > 50 {
+			problem.Abort(c, http.StatusBadRequest, "Display name must not exceed 50 characters")
+			return
+		}
+		set["display_name"] = name
+	}
+	if req.BaseCurrency != nil {
+		currency := strings.ToUpper(strings.TrimSpace(*req.BaseCurrency))
+		if !currencyRegex.MatchString(currency) {
+			problem.Abort(c, http.StatusBadRequest, "Base currency must be a 3-letter ISO 4217 code")
+			return
+		}
+		set["base_currency"] = currency
+	}
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" {
+			problem.Abort(c, http.StatusBadRequest, "Unknown timezone")
+			return
+		}
+		set["timezone"] = *req.Timezone
+	}
+	res, err := UserDataCollection.UpdateOne(ctx, bson.M{"_id": currentUserID(c)}, bson.M{"$set": set})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update profile")
+		return
+	}
+	if res.MatchedCount == 0 {
+		problem.Abort(c, http.StatusNotFound, "User not found")
+		return
+	}
+	getProfile(c)
+}
+
+func changePassword(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req PasswordChange
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	if user.Password == "" {
+		if !confirmPasswordless(c, user, req.Code) {
+			return
+		}
+	} else if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)) != nil {
+		problem.Render(c, problem.New(http.StatusUnauthorized, "Current password is incorrect").WithCode(problem.CodeInvalidCredentials))
+		return
+	}
+	if msg := middleware.ValidatePassword(req.NewPassword, req.ConfirmPassword); msg != "" {
+		problem.Render(c, problem.New(http.StatusBadRequest, msg).
+			WithCode(problem.CodeValidationFailed).
+			WithField("new_password", msg))
+		return
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Error hashing password")
+		return
+	}
+	_, err = UserDataCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+		"password":   string(hashed),
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update password")
+		return
+	}
+	// Sign out every other device; the caller keeps the session it used.
+	_, err = SessionCollection.DeleteMany(ctx, bson.M{
+		"user_id":    user.ID,
+		"token_hash": bson.M{"$ne": middleware.HashToken(middleware.BearerToken(c))},
+	})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated"})
+}
+
+// deleteAccount disables the account and signs it out everywhere. The data
+// stays until the retention grace period ends, so an admin can still restore
+// the account by enabling it.
+func deleteAccount(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req PasswordConfirmation
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	if user.Password == "" {
+		if !confirmPasswordless(c, user, req.Code) {
+			return
+		}
+	} else if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+		problem.Render(c, problem.New(http.StatusUnauthorized, "Password is incorrect").WithCode(problem.CodeInvalidCredentials))
+		return
+	}
+	shared, err := WorkspaceCollection.CountDocuments(ctx, bson.M{"owner_id": user.ID, "members.1": bson.M{"$exists": true}})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch workspaces")
+		return
+	}
+	if shared > 0 {
+		problem.Render(c, problem.New(http.StatusConflict, "Transfer or delete the workspaces you share before deleting your account").WithCode("workspace_has_members"))
+		return
+	}
+	// Nobody may join the user's workspaces during the grace period, or the
+	// purge would delete data that is no longer theirs alone.
+	owned, err := WorkspaceCollection.Distinct(ctx, "_id", bson.M{"owner_id": user.ID})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch workspaces")
+		return
+	}
+	now := time.Now()
+	_, err = InvitationCollection.UpdateMany(ctx,
+		bson.M{"workspace_id": bson.M{"$in": append(bson.A{}, owned...)}, "status": InvitationPending},
+		bson.M{"$set": bson.M{"status": InvitationRevoked, "responded_at": now}},
+	)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to revoke invitations")
+		return
+	}
+	_, err = UserDataCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+		"disabled":   true,
+		"deleted_at": now,
+		"updated_at": now,
+	}})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to delete user")
+		return
+	}
+	if _, err := SessionCollection.DeleteMany(ctx, bson.M{"user_id": user.ID}); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to delete sessions")
+		return
+	}
+	if _, err := APIKeyCollection.DeleteMany(ctx, bson.M{"user_id": user.ID}); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to delete API keys")
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":      "Account scheduled for deletion",
+		"delete_after": now.Add(cfg.Retention.GracePeriod),
+	})
+}
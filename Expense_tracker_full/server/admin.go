@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"gin-app/middleware"
+	"gin-app/problem"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type RoleUpdate struct {
+	Role string `json:"role"`
+}
+
+type CategoryStat struct {
+	Category string  `bson:"_id" json:"category"`
+	Count    int64   `bson:"count" json:"count"`
+	Total    float64 `bson:"total" json:"total"`
+}
+
+// roleForEmail lets operators bootstrap admins through the configured admin
+// emails, checked when an account is created.
+func roleForEmail(email string) string {
+	for _, admin := range cfg.Auth.AdminEmails {
+		if strings.EqualFold(admin, email) {
+			return middleware.RoleAdmin
+		}
+	}
+	return middleware.RoleMember
+}
+
+func adminTargetID(c *gin.Context) (primitive.ObjectID, bool) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return objID, false
+	}
+	if objID == currentUserID(c) {
+		problem.Abort(c, http.StatusBadRequest, "Admins cannot change their own account here")
+		return objID, false
+	}
+	return objID, true
+}
+
+func listUsers(c *gin.Context) {
+	ctx := c.Request.Context()
+	page, _ := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+	limit, _ := strconv.ParseInt(c.DefaultQuery("limit", "50"), 10, 64)
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+	filter := bson.M{}
+	if role := c.Query("role"); role != "" {
+		filter["role"] = role
+	}
+	opts := options.Find().SetSort(bson.M{"created_at": 1}).SetSkip((page - 1) * limit).SetLimit(limit)
+	cur, err := UserDataCollection.Find(ctx, filter, opts)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch users")
+		return
+	}
+	users := []SignupUser{}
+	if err := cur.All(ctx, &users); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch users")
+		return
+	}
+	for i := range users {
+		users[i].HasPassword = users[i].Password != ""
+	}
+	total, err := UserDataCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to count users")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users, "total": total, "page": page, "limit": limit})
+}
+
+func setUserDisabled(disabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		objID, ok := adminTargetID(c)
+		if !ok {
+			return
+		}
+		update := bson.M{"$set": bson.M{
+			"disabled":   disabled,
+			"updated_at": time.Now(),
+		}}
+		filter := bson.M{"_id": objID}
+		if !disabled {
+			// Enabling also cancels a pending account deletion; anonymised
+			// accounts can't be brought back.
+			update["$unset"] = bson.M{"deleted_at": ""}
+			filter["anonymised_at"] = bson.M{"$exists": false}
+		}
+		res, err := UserDataCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Failed to update user")
+			return
+		}
+		if res.MatchedCount == 0 {
+			problem.Abort(c, http.StatusNotFound, "User not found")
+			return
+		}
+		if disabled {
+			if _, err := SessionCollection.DeleteMany(ctx, bson.M{"user_id": objID}); err != nil {
+				problem.Abort(c, http.StatusInternalServerError, "Failed to revoke sessions")
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "User updated"})
+	}
+}
+
+func setUserRole(c *gin.Context) {
+	ctx := c.Request.Context()
+	objID, ok := adminTargetID(c)
+	if !ok {
+		return
+	}
+	var req RoleUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !slices.Contains(middleware.AllRoles, req.Role) {
+		problem.Abort(c, http.StatusBadRequest, "Role must be one of admin, member, viewer")
+		return
+	}
+	res, err := UserDataCollection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{
+		"role":       req.Role,
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update user")
+		return
+	}
+	if res.MatchedCount == 0 {
+		problem.Abort(c, http.StatusNotFound, "User not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User updated"})
+}
+
+func getSystemStats(c *gin.Context) {
+	ctx := c.Request.Context()
+	users, err := UserDataCollection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to compute statistics")
+		return
+	}
+	disabled, err := UserDataCollection.CountDocuments(ctx, bson.M{"disabled": true})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to compute statistics")
+		return
+	}
+	sessions, err := SessionCollection.CountDocuments(ctx, bson.M{"expires_at": bson.M{"$gt": time.Now()}})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to compute statistics")
+		return
+	}
+	roles, err := countUsersByRole(ctx)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to compute statistics")
+		return
+	}
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$category",
+			"count": bson.M{"$sum": 1},
+			"total": bson.M{"$sum": "$amount"},
+		}}},
+		{{Key: "$sort", Value: bson.M{"total": -1}}},
+	})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to compute statistics")
+		return
+	}
+	categories := []CategoryStat{}
+	if err := cur.All(ctx, &categories); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to compute statistics")
+		return
+	}
+	var expenseCount int64
+	var expenseTotal float64
+	for _, cat := range categories {
+		expenseCount += cat.Count
+		expenseTotal += cat.Total
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"users":           users,
+		"disabled_users":  disabled,
+		"users_by_role":   roles,
+		"active_sessions": sessions,
+		"expense_count":   expenseCount,
+		"expense_total":   expenseTotal,
+		"categories":      categories,
+	})
+}
+
+func countUsersByRole(ctx context.Context) (map[string]int64, error) {
+	cur, err := UserDataCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$ifNull": bson.A{"$role", middleware.RoleMember}},
+			"count": bson.M{"$sum": 1},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Role  string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	roles := make(map[string]int64, len(middleware.AllRoles))
+	for _, role := range middleware.AllRoles {
+		roles[role] = 0
+	}
+	for _, row := range rows {
+		roles[row.Role] += row.Count
+	}
+	return roles, nil
+}
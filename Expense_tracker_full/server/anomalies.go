@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gin-app/middleware"
+	"gin-app/problem"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AnomalyOutlier   = "amount_outlier"
+	AnomalyDuplicate = "duplicate"
+)
+
+// CategoryStats keeps running sums rather than a mean and variance so that
+// creates, edits and deletes can all adjust it with a single atomic $inc.
+type CategoryStats struct {
+	WorkspaceID primitive.ObjectID `bson:"workspace_id"`
+	Category    string             `bson:"category"`
+	Count       int64              `bson:"count"`
+	Sum         float64            `bson:"sum"`
+	SumSq       float64            `bson:"sum_sq"`
+}
+
+func (s CategoryStats) meanStdDev() (float64, float64) {
+	if s.Count < 2 {
+		return s.Sum, 0
+	}
+	n := float64(s.Count)
+	mean := s.Sum / n
+	variance := (s.SumSq - s.Sum*s.Sum/n) / (n - 1)
+	return mean, math.Sqrt(math.Max(variance, 0))
+}
+
+type Anomaly struct {
+	ID          primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	WorkspaceID primitive.ObjectID  `json:"-" bson:"workspace_id"`
+	ExpenseID   primitive.ObjectID  `json:"expense_id" bson:"expense_id"`
+	Kind        string              `json:"kind" bson:"kind"`
+	Reason      string              `json:"reason" bson:"reason"`
+	Score       *float64            `json:"score,omitempty" bson:"score,omitempty"`
+	Mean        *float64            `json:"mean,omitempty" bson:"mean,omitempty"`
+	StdDev      *float64            `json:"stddev,omitempty" bson:"stddev,omitempty"`
+	DuplicateOf *primitive.ObjectID `json:"duplicate_of,omitempty" bson:"duplicate_of,omitempty"`
+	CreatedAt   time.Time           `json:"created_at" bson:"created_at"`
+	DismissedAt *time.Time          `json:"dismissed_at,omitempty" bson:"dismissed_at,omitempty"`
+	Expense     *Expense            `json:"expense,omitempty" bson:"expense,omitempty"`
+}
+
+var (
+	AnomalyCollection       *mongo.Collection
+	CategoryStatsCollection *mongo.Collection
+)
+
+// adjustCategoryStats adds (sign 1) or removes (sign -1) an amount from the
+// running statistics for its category.
+func adjustCategoryStats(ctx context.Context, workspaceID primitive.ObjectID, category string, amount float64, sign int) error {
+	s := float64(sign)
+	_, err := CategoryStatsCollection.UpdateOne(ctx,
+		bson.M{"workspace_id": workspaceID, "category": category},
+		bson.M{"$inc": bson.M{"count": sign, "sum": s * amount, "sum_sq": s * amount * amount}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// detectAnomalies checks e against the category statistics as they stood
+// before e was counted, and against the workspace's other recent expenses.
+func detectAnomalies(ctx context.Context, e Expense) ([]Anomaly, error) {
+	var found []Anomaly
+	now := time.Now()
+
+	var stats CategoryStats
+	err := CategoryStatsCollection.FindOne(ctx, bson.M{"workspace_id": e.WorkspaceID, "category": e.Category}).Decode(&stats)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if stats.Count >= int64(cfg.Anomalies.MinSamples) {
+		mean, stddev := stats.meanStdDev()
+		if stddev > 0 {
+			score := (e.Amount - mean) / stddev
+			if math.Abs(score) >= cfg.Anomalies.ZThreshold {
+				found = append(found, Anomaly{
+					WorkspaceID: e.WorkspaceID,
+					ExpenseID:   e.ID,
+					Kind:        AnomalyOutlier,
+					Reason:      fmt.Sprintf("%.2f is %.1f standard deviations from the usual %.2f for %q", e.Amount, math.Abs(score), mean, e.Category),
+					Score:       &score,
+					Mean:        &mean,
+					StdDev:      &stddev,
+					CreatedAt:   now,
+				})
+			}
+		}
+	}
+
+	window := cfg.Anomalies.DuplicateWindow
+	var dup Expense
+	err = collection.FindOne(ctx, bson.M{
+		"workspace_id": e.WorkspaceID,
+		"_id":          bson.M{"$ne": e.ID},
+		"amount":       e.Amount,
+		"title":        primitive.Regex{Pattern: "^" + regexp.QuoteMeta(e.Title) + "$", Options: "i"},
+		"date":         bson.M{"$gte": e.Date.Add(-window), "$lte": e.Date.Add(window)},
+	}, options.FindOne().SetSort(bson.M{"date": 1})).Decode(&dup)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if err == nil {
+		found = append(found, Anomaly{
+			WorkspaceID: e.WorkspaceID,
+			ExpenseID:   e.ID,
+			Kind:        AnomalyDuplicate,
+			Reason:      fmt.Sprintf("%q for %.2f was already recorded within %s", e.Title, e.Amount, window),
+			DuplicateOf: &dup.ID,
+			CreatedAt:   now,
+		})
+	}
+	return found, nil
+}
+
+// trackExpense runs anomaly detection for a new or edited expense and then
+// counts it in the category statistics. previous is the stored version for
+// edits. Failures are logged rather than failing the write that triggered
+// them, which has already succeeded.
+func trackExpense(ctx context.Context, e Expense, previous *Expense) {
+	log := middleware.Logger(ctx)
+	if previous != nil {
+		if err := adjustCategoryStats(ctx, previous.WorkspaceID, previous.Category, previous.Amount, -1); err != nil {
+			log.Error("update category statistics", "error", err)
+		}
+		// Re-evaluate from scratch; flags the user already dismissed stay.
+		if _, err := AnomalyCollection.DeleteMany(ctx, bson.M{"expense_id": e.ID, "dismissed_at": bson.M{"$exists": false}}); err != nil {
+			log.Error("clear anomalies", "error", err)
+		}
+	}
+	found, err := detectAnomalies(ctx, e)
+	if err != nil {
+		log.Error("detect anomalies", "error", err)
+	}
+	if previous != nil && len(found) > 0 {
+		dismissed, err := AnomalyCollection.Distinct(ctx, "kind", bson.M{"expense_id": e.ID})
+		if err != nil {
+			log.Error("load dismissed anomalies", "error", err)
+		}
+		found = withoutKinds(found, dismissed)
+	}
+	if len(found) > 0 {
+		docs := make([]any, len(found))
+		for i := range found {
+			docs[i] = found[i]
+		}
+		if _, err := AnomalyCollection.InsertMany(ctx, docs); err != nil {
+			log.Error("record anomalies", "error", err)
+		}
+	}
+	if err := adjustCategoryStats(ctx, e.WorkspaceID, e.Category, e.Amount, 1); err != nil {
+		log.Error("update category statistics", "error", err)
+	}
+}
+
+func withoutKinds(found []Anomaly, kinds []any) []Anomaly {
+	var kept []Anomaly
+	for _, a := range found {
+		skip := false
+		for _, k := range kinds {
+			if k == a.Kind {
+				skip = true
+			}
+		}
+		if !skip {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+func untrackExpense(ctx context.Context, e Expense) {
+	log := middleware.Logger(ctx)
+	if err := adjustCategoryStats(ctx, e.WorkspaceID, e.Category, e.Amount, -1); err != nil {
+		log.Error("update category statistics", "error", err)
+	}
+	if _, err := AnomalyCollection.DeleteMany(ctx, bson.M{"expense_id": e.ID}); err != nil {
+		log.Error("clear anomalies", "error", err)
+	}
+}
+
+func getAnomalies(c *gin.Context) {
+	ctx := c.Request.Context()
+	match := bson.M{"workspace_id": currentWorkspaceID(c)}
+	if include, _ := strconv.ParseBool(c.Query("include_dismissed")); !include {
+		match["dismissed_at"] = bson.M{"$exists": false}
+	}
+	if kind := c.Query("kind"); kind != "" {
+		match["kind"] = kind
+	}
+	cur, err := AnomalyCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.M{"created_at": -1}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         collection.Name(),
+			"localField":   "expense_id",
+			"foreignField": "_id",
+			"as":           "expense",
+		}}},
+		{{Key: "$unwind", Value: bson.M{"path": "$expense", "preserveNullAndEmptyArrays": true}}},
+	})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch anomalies")
+		return
+	}
+	anomalies := []Anomaly{}
+	if err := cur.All(ctx, &anomalies); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch anomalies")
+		return
+	}
+	c.JSON(http.StatusOK, anomalies)
+}
+
+func dismissAnomaly(c *gin.Context) {
+	ctx := c.Request.Context()
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	res, err := AnomalyCollection.UpdateOne(ctx,
+		bson.M{"_id": objID, "workspace_id": currentWorkspaceID(c), "dismissed_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"dismissed_at": time.Now()}},
+	)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to dismiss anomaly")
+		return
+	}
+	if res.MatchedCount == 0 {
+		problem.Abort(c, http.StatusNotFound, "Anomaly not found or already dismissed")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Anomaly dismissed"})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// statsOf keeps running sums the way adjustCategoryStats does, adding each
+// amount and then taking away the removed ones.
+func statsOf(added []float64, removed ...float64) CategoryStats {
+	var s CategoryStats
+	for _, v := range added {
+		s.Count++
+		s.Sum += v
+		s.SumSq += v * v
+	}
+	for _, v := range removed {
+		s.Count--
+		s.Sum -= v
+		s.SumSq -= v * v
+	}
+	return s
+}
+
+func TestCategoryStatsMeanStdDev(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats CategoryStats
+		want  []float64
+	}{
+		{name: "empty", stats: statsOf(nil), want: nil},
+		{name: "one expense", stats: statsOf([]float64{42}), want: []float64{42}},
+		{name: "several expenses", stats: statsOf([]float64{2, 4, 4, 4, 5, 5, 7, 9}), want: []float64{2, 4, 4, 4, 5, 5, 7, 9}},
+		{name: "after a delete", stats: statsOf([]float64{10, 20, 30, 1000}, 1000), want: []float64{10, 20, 30}},
+		{name: "edited back to one", stats: statsOf([]float64{12.5, 80}, 80), want: []float64{12.5}},
+		// Rounding in the running sums can leave the variance just below zero.
+		{name: "identical amounts", stats: statsOf([]float64{0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1}), want: []float64{0.1, 0.1, 0.1, 0.1, 0.1, 0.1, 0.1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mean, sd := tt.stats.meanStdDev()
+			wantMean, wantSD := meanStdDev(tt.want)
+			if !near(mean, wantMean) || !near(sd, wantSD) {
+				t.Errorf("meanStdDev = (%v, %v), want (%v, %v)", mean, sd, wantMean, wantSD)
+			}
+			if math.IsNaN(sd) || sd < 0 {
+				t.Errorf("standard deviation %v", sd)
+			}
+		})
+	}
+}
+
+func TestWithoutKinds(t *testing.T) {
+	outlier := Anomaly{Kind: AnomalyOutlier}
+	duplicate := Anomaly{Kind: AnomalyDuplicate}
+	tests := []struct {
+		name  string
+		found []Anomaly
+		kinds []any
+		want  []string
+	}{
+		{name: "nothing dismissed", found: []Anomaly{outlier, duplicate}, kinds: nil, want: []string{AnomalyOutlier, AnomalyDuplicate}},
+		{name: "one kind dismissed", found: []Anomaly{outlier, duplicate}, kinds: []any{AnomalyDuplicate}, want: []string{AnomalyOutlier}},
+		{name: "all dismissed", found: []Anomaly{outlier, duplicate}, kinds: []any{AnomalyOutlier, AnomalyDuplicate}, want: nil},
+		{name: "unrelated kind", found: []Anomaly{outlier}, kinds: []any{"other"}, want: []string{AnomalyOutlier}},
+		{name: "nothing found", found: nil, kinds: []any{AnomalyOutlier}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, a := range withoutKinds(tt.found, tt.kinds) {
+				got = append(got, a.Kind)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+// Package api holds the OpenAPI description of the expense server, serves it
+// and validates incoming requests against it.
+package api
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"gin-app/problem"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+)
+
+//go:generate go run github.com/oapi-codegen/oapi-codegen/v2/cmd/oapi-codegen@v2.4.1 -config ../client/oapi-codegen.yaml openapi.yaml
+
+//go:embed openapi.yaml
+var specYAML []byte
+
+type Spec struct {
+	doc    *openapi3.T
+	json   []byte
+	router routers.Router
+}
+
+func Load(ctx context.Context) (*Spec, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(specYAML)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(ctx); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	// Route on paths alone; the documented server URL is only an example and
+	// must not restrict which Host headers are accepted.
+	servers := doc.Servers
+	doc.Servers = nil
+	router, err := gorillamux.NewRouter(doc)
+	doc.Servers = servers
+	if err != nil {
+		return nil, err
+	}
+	return &Spec{doc: doc, json: data, router: router}, nil
+}
+
+func (s *Spec) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", s.json)
+	}
+}
+
+// Validator rejects requests whose parameters or bodies do not match the
+// document. Authentication is left to the session middleware, and routes the
+// document does not describe pass through untouched.
+func (s *Spec) Validator() gin.HandlerFunc {
+	options := &openapi3filter.Options{
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+	}
+	return func(c *gin.Context) {
+		route, params, err := s.router.FindRoute(c.Request)
+		if err != nil {
+			c.Next()
+			return
+		}
+		err = openapi3filter.ValidateRequest(c.Request.Context(), &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: params,
+			Route:      route,
+			Options:    options,
+		})
+		if err != nil {
+			problem.Render(c, validationProblem(err))
+			return
+		}
+		c.Next()
+	}
+}
+
+func validationProblem(err error) *problem.Problem {
+	reqErr, ok := err.(*openapi3filter.RequestError)
+	if !ok {
+		return problem.New(http.StatusBadRequest, err.Error())
+	}
+	p := problem.New(http.StatusBadRequest, "").WithCode(problem.CodeValidationFailed)
+	schemaErr, _ := reqErr.Err.(*openapi3.SchemaError)
+	switch {
+	case reqErr.Parameter != nil:
+		reason := reqErr.Reason
+		if schemaErr != nil {
+			reason = schemaErr.Reason
+		} else if reason == "" && reqErr.Err != nil {
+			reason = reqErr.Err.Error()
+		}
+		p.Detail = "Invalid " + reqErr.Parameter.In + " parameter " + reqErr.Parameter.Name
+		p.WithField(reqErr.Parameter.Name, reason)
+	case schemaErr != nil:
+		p.Detail = "Invalid request body"
+		p.WithField(strings.Join(schemaErr.JSONPointer(), "."), schemaErr.Reason)
+	default:
+		p.Detail = "Invalid request: " + reqErr.Error()
+	}
+	return p
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/csv"
+	"gin-app/middleware"
+	"gin-app/problem"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+var APIKeyCollection *mongo.Collection
+
+func createAPIKey(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Scopes) == 0 {
+		problem.Abort(c, http.StatusBadRequest, "Name and at least one scope are required")
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(middleware.AllScopes, scope) {
+			problem.Abort(c, http.StatusBadRequest, "Unknown scope: "+scope)
+			return
+		}
+	}
+	secret, err := randomString(24)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to generate API key")
+		return
+	}
+	key := middleware.APIKeyPrefix + secret
+	apiKey := middleware.APIKey{
+		UserID:    currentUserID(c),
+		Name:      req.Name,
+		Prefix:    key[:len(middleware.APIKeyPrefix)+8],
+		KeyHash:   middleware.HashToken(key),
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(req.Scopes))),
+		CreatedAt: time.Now(),
+	}
+	res, err := APIKeyCollection.InsertOne(ctx, apiKey)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+	apiKey.ID = res.InsertedID.(primitive.ObjectID)
+	// The plaintext key is only ever returned here; we store its hash.
+	c.JSON(http.StatusCreated, gin.H{"key": key, "api_key": apiKey})
+}
+
+func listAPIKeys(c *gin.Context) {
+	ctx := c.Request.Context()
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cur, err := APIKeyCollection.Find(ctx, bson.M{"user_id": currentUserID(c)}, opts)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch API keys")
+		return
+	}
+	keys := []middleware.APIKey{}
+	if err := cur.All(ctx, &keys); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch API keys")
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+func revokeAPIKey(c *gin.Context) {
+	ctx := c.Request.Context()
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	res, err := APIKeyCollection.UpdateOne(ctx,
+		bson.M{"_id": objID, "user_id": currentUserID(c), "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+	if res.MatchedCount == 0 {
+		problem.Abort(c, http.StatusNotFound, "API key not found")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func exportExpenses(c *gin.Context) {
+	ctx := c.Request.Context()
+	f, ok := expenseFilterFromQuery(c)
+	if !ok {
+		return
+	}
+	opts := options.Find().SetSort(bson.M{"date": 1})
+	cur, err := collection.Find(ctx, f.Match(currentWorkspaceID(c)), opts)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch expenses")
+		return
+	}
+	defer cur.Close(ctx)
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", `attachment; filename="expenses.csv"`)
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"id", "date", "title", "category", "amount", "description", "tags"})
+	for cur.Next(ctx) {
+		var e Expense
+		if err := cur.Decode(&e); err != nil {
+			continue
+		}
+		w.Write([]string{
+			e.ID.Hex(),
+			e.Date.Format(time.RFC3339),
+			e.Title,
+			e.Category,
+			strconv.FormatFloat(e.Amount, 'f', 2, 64),
+			string(e.Description),
+			strings.Join(e.Tags, ";"),
+		})
+	}
+	w.Flush()
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"gin-app/events"
+	"gin-app/middleware"
+	"gin-app/problem"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	StatusDraft      = "draft"
+	StatusSubmitted  = "submitted"
+	StatusApproved   = "approved"
+	StatusRejected   = "rejected"
+	StatusReimbursed = "reimbursed"
+
+	maxCommentLength = 2000
+)
+
+// transitions lists the statuses an expense may move to from each status.
+// Rejected expenses go back to draft to be corrected and resubmitted.
+var transitions = map[string][]string{
+	StatusDraft:     {StatusSubmitted},
+	StatusSubmitted: {StatusDraft, StatusApproved, StatusRejected},
+	StatusApproved:  {StatusReimbursed},
+	StatusRejected:  {StatusDraft},
+}
+
+// editableStatuses are the statuses in which an expense may still be changed
+// or deleted; once submitted it is locked until sent back to draft.
+var editableStatuses = []string{StatusDraft, StatusRejected}
+
+// ExpenseComment is a note on an expense. Comments left with a status change
+// record that status, so the list doubles as the approval history.
+type ExpenseComment struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Body      string             `json:"body" bson:"body"`
+	Status    string             `json:"status,omitempty" bson:"status,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+type StatusRequest struct {
+	Status     string `json:"status"`
+	ApproverID string `json:"approver_id"`
+	Comment    string `json:"comment"`
+}
+
+type ApproverRequest struct {
+	ApproverID string `json:"approver_id"`
+}
+
+type CommentRequest struct {
+	Body string `json:"body"`
+}
+
+func findExpense(c *gin.Context) (Expense, bool) {
+	var e Expense
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return e, false
+	}
+	err = collection.FindOne(c.Request.Context(), bson.M{"_id": objID, "workspace_id": currentWorkspaceID(c)}).Decode(&e)
+	if err == mongo.ErrNoDocuments {
+		problem.Abort(c, http.StatusNotFound, "Expense not found")
+		return e, false
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch expense")
+		return e, false
+	}
+	return e, true
+}
+
+// abortLocked explains why a write matching editableStatuses found nothing:
+// the expense is gone, it is in review, or another request changed it first.
+func abortLocked(c *gin.Context, id primitive.ObjectID) {
+	var e Expense
+	opts := options.FindOne().SetProjection(bson.M{"status": 1})
+	err := collection.FindOne(c.Request.Context(), bson.M{"_id": id, "workspace_id": currentWorkspaceID(c)}, opts).Decode(&e)
+	if err == mongo.ErrNoDocuments {
+		problem.Abort(c, http.StatusNotFound, "Expense not found")
+		return
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch expense")
+		return
+	}
+	if slices.Contains(editableStatuses, e.Status) {
+		problem.Render(c, problem.New(http.StatusConflict, "Expense was changed by another request").WithCode(problem.CodeConflict))
+		return
+	}
+	problem.Render(c, problem.New(http.StatusConflict, "Expense is in review and can't be changed").WithCode("expense_locked"))
+}
+
+// validApprover checks that id names an owner or editor of the current
+// workspace other than the submitter.
+func validApprover(c *gin.Context, id string, submitter primitive.ObjectID) (primitive.ObjectID, bool) {
+	invalid := func(msg string) (primitive.ObjectID, bool) {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Invalid approver").
+			WithCode(problem.CodeValidationFailed).
+			WithField("approver_id", msg))
+		return primitive.NilObjectID, false
+	}
+	approverID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return invalid("must be a user ID")
+	}
+	if approverID == submitter {
+		return invalid("must not be the submitter")
+	}
+	n, err := WorkspaceCollection.CountDocuments(c.Request.Context(), bson.M{
+		"_id": currentWorkspaceID(c),
+		"members": bson.M{"$elemMatch": bson.M{
+			"user_id": approverID,
+			"role":    bson.M{"$in": bson.A{middleware.WorkspaceOwner, middleware.WorkspaceEditor}},
+		}},
+	})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch workspace")
+		return primitive.NilObjectID, false
+	}
+	if n == 0 {
+		return invalid("must be an owner or editor of the workspace")
+	}
+	return approverID, true
+}
+
+// setExpenseStatus moves an expense through the approval workflow. The
+// submitter submits and withdraws, the assigned approver approves or rejects
+// (with a comment), and the workspace owner marks approved expenses as
+// reimbursed and can reopen rejected ones.
+func setExpenseStatus(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req StatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Comment = strings.TrimSpace(req.Comment)
+	if len(req.Comment) > maxCommentLength {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Comment too long").
+			WithCode(problem.CodeValidationFailed).
+			WithField("comment", "must be at most 2000 characters"))
+		return
+	}
+	e, ok := findExpense(c)
+	if !ok {
+		return
+	}
+	if !slices.Contains(transitions[e.Status], req.Status) {
+		problem.Render(c, problem.New(http.StatusConflict, "Expense can't move from "+e.Status+" to "+req.Status).WithCode("invalid_transition"))
+		return
+	}
+
+	userID := currentUserID(c)
+	owner := c.GetString("workspace_role") == middleware.WorkspaceOwner
+	var allowed bool
+	switch req.Status {
+	case StatusSubmitted:
+		allowed = userID == e.UserID
+	case StatusDraft:
+		allowed = userID == e.UserID || e.Status == StatusRejected && owner
+	case StatusApproved, StatusRejected:
+		allowed = e.ApproverID != nil && userID == *e.ApproverID
+	case StatusReimbursed:
+		allowed = owner
+	}
+	if !allowed {
+		problem.Render(c, problem.New(http.StatusForbidden, "You can't move this expense to "+req.Status).WithCode("transition_forbidden"))
+		return
+	}
+	if req.Status == StatusRejected && req.Comment == "" {
+		problem.Render(c, problem.New(http.StatusBadRequest, "A reason is required").
+			WithCode(problem.CodeValidationFailed).
+			WithField("comment", "is required when rejecting"))
+		return
+	}
+
+	now := time.Now()
+	set := bson.M{"status": req.Status, "status_changed_at": now}
+	if req.Status == StatusSubmitted {
+		approverID, ok := validApprover(c, req.ApproverID, e.UserID)
+		if !ok {
+			return
+		}
+		set["approver_id"] = approverID
+	}
+	comment := ExpenseComment{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Body:      req.Comment,
+		Status:    req.Status,
+		CreatedAt: now,
+	}
+	update := bson.M{"$set": set, "$push": bson.M{"comments": comment}}
+	if req.Status == StatusDraft {
+		// A resubmission names its approver afresh.
+		update["$unset"] = bson.M{"approver_id": ""}
+	}
+	done, err := stampChange(ctx, e.WorkspaceID, update)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update expense")
+		return
+	}
+	defer done()
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var saved Expense
+	err = collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": e.ID, "workspace_id": e.WorkspaceID, "status": e.Status},
+		update,
+		opts,
+	).Decode(&saved)
+	if err == mongo.ErrNoDocuments {
+		problem.Render(c, problem.New(http.StatusConflict, "Expense status changed; reload and try again").WithCode("invalid_transition"))
+		return
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update expense")
+		return
+	}
+	publishExpense(events.ExpenseUpdated, saved.WorkspaceID, saved.ID, saved)
+	c.JSON(http.StatusOK, saved)
+}
+
+// setExpenseApprover reassigns a submitted expense, for example when the
+// approver is away. Only the workspace owner may do this.
+func setExpenseApprover(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req ApproverRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if c.GetString("workspace_role") != middleware.WorkspaceOwner {
+		problem.Abort(c, http.StatusForbidden, "Only the workspace owner can reassign approvals")
+		return
+	}
+	e, ok := findExpense(c)
+	if !ok {
+		return
+	}
+	if e.Status != StatusSubmitted {
+		problem.Render(c, problem.New(http.StatusConflict, "Only submitted expenses can be reassigned").WithCode("invalid_transition"))
+		return
+	}
+	approverID, ok := validApprover(c, req.ApproverID, e.UserID)
+	if !ok {
+		return
+	}
+	update := bson.M{"$set": bson.M{"approver_id": approverID}}
+	done, err := stampChange(ctx, e.WorkspaceID, update)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update expense")
+		return
+	}
+	defer done()
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var saved Expense
+	err = collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": e.ID, "workspace_id": e.WorkspaceID, "status": StatusSubmitted},
+		update,
+		opts,
+	).Decode(&saved)
+	if err == mongo.ErrNoDocuments {
+		problem.Render(c, problem.New(http.StatusConflict, "Expense status changed; reload and try again").WithCode("invalid_transition"))
+		return
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update expense")
+		return
+	}
+	publishExpense(events.ExpenseUpdated, saved.WorkspaceID, saved.ID, saved)
+	c.JSON(http.StatusOK, gin.H{"message": "Approver updated"})
+}
+
+func addExpenseComment(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req CommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Body = strings.TrimSpace(req.Body)
+	if req.Body == "" || len(req.Body) > maxCommentLength {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Invalid comment").
+			WithCode(problem.CodeValidationFailed).
+			WithField("body", "must be between 1 and 2000 characters"))
+		return
+	}
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	comment := ExpenseComment{
+		ID:        primitive.NewObjectID(),
+		UserID:    currentUserID(c),
+		Body:      req.Body,
+		CreatedAt: time.Now(),
+	}
+	update := bson.M{"$push": bson.M{"comments": comment}}
+	done, err := stampChange(ctx, currentWorkspaceID(c), update)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to add comment")
+		return
+	}
+	defer done()
+	res, err := collection.UpdateOne(ctx,
+		bson.M{"_id": objID, "workspace_id": currentWorkspaceID(c)},
+		update,
+	)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to add comment")
+		return
+	}
+	if res.MatchedCount == 0 {
+		problem.Abort(c, http.StatusNotFound, "Expense not found")
+		return
+	}
+	c.JSON(http.StatusCreated, comment)
+}
+
+// getApprovalQueue lists submitted expenses waiting on an approver, oldest
+// submission first. It defaults to the caller's own queue.
+func getApprovalQueue(c *gin.Context) {
+	ctx := c.Request.Context()
+	approverID := currentUserID(c)
+	if v := c.Query("approver_id"); v != "" {
+		id, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			problem.Render(c, problem.New(http.StatusBadRequest, "Invalid approver").
+				WithCode(problem.CodeValidationFailed).
+				WithField("approver_id", "must be a user ID"))
+			return
+		}
+		approverID = id
+	}
+	filter := bson.M{"workspace_id": currentWorkspaceID(c), "status": StatusSubmitted, "approver_id": approverID}
+	cur, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"status_changed_at": 1}))
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch approvals")
+		return
+	}
+	queue := []Expense{}
+	if err := cur.All(ctx, &queue); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch approvals")
+		return
+	}
+	c.JSON(http.StatusOK, queue)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"gin-app/problem"
+	"gin-app/statements"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const maxBudgets = 100
+
+type BudgetStatus struct {
+	Category string  `json:"category"`
+	Limit    float64 `json:"limit"`
+	Spent    float64 `json:"spent"`
+	Status   string  `json:"status"`
+}
+
+// getBudgets returns the workspace's monthly category limits along with this
+// month's spending against each, in the caller's timezone, by category.
+func getBudgets(c *gin.Context) {
+	ctx := c.Request.Context()
+	workspace, ok := findCurrentWorkspace(c)
+	if !ok {
+		return
+	}
+	loc := userLocation(ctx, currentUserID(c))
+	start := startOfMonth(time.Now().In(loc))
+	stats, err := categoryStats(ctx, bson.M{"workspace_id": workspace.ID}, start, start.AddDate(0, 1, 0))
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to compute budget status")
+		return
+	}
+	spent := make(map[string]float64, len(stats))
+	for _, stat := range stats {
+		spent[stat.Category] = stat.Total
+	}
+	status := []BudgetStatus{}
+	for category, limit := range workspace.Budgets {
+		status = append(status, BudgetStatus{
+			Category: category,
+			Limit:    limit,
+			Spent:    spent[category],
+			Status:   statements.BudgetStatus(spent[category], limit),
+		})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Category < status[j].Category })
+	c.JSON(http.StatusOK, gin.H{"month": start.Format(monthLayout), "budgets": status})
+}
+
+// setBudgets replaces the workspace's budgets; a category left out has no
+// limit.
+func setBudgets(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req map[string]float64
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req) > maxBudgets {
+		problem.Abort(c, http.StatusBadRequest, "At most 100 budgets are allowed")
+		return
+	}
+	budgets := make(map[string]float64, len(req))
+	p := problem.New(http.StatusBadRequest, "Invalid budgets").WithCode(problem.CodeValidationFailed)
+	for category, limit := range req {
+		category = strings.TrimSpace(category)
+		if category == "" || strings.HasPrefix(category, "$") || strings.Contains(category, ".") {
+			p = p.WithField(category, "category must not be empty, start with $ or contain a dot")
+			continue
+		}
+		if limit <= 0 {
+			p = p.WithField(category, "budget must be greater than 0")
+			continue
+		}
+		budgets[category] = limit
+	}
+	if len(p.Errors) > 0 {
+		problem.Render(c, p)
+		return
+	}
+	res, err := WorkspaceCollection.UpdateOne(ctx, bson.M{"_id": currentWorkspaceID(c)}, bson.M{"$set": bson.M{
+		"budgets":    budgets,
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update budgets")
+		return
+	}
+	if res.MatchedCount == 0 {
+		problem.Abort(c, http.StatusNotFound, "Workspace not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Budgets updated"})
+}
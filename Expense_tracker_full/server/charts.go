@@ -0,0 +1,122 @@
+package main
+
+import (
+	"gin-app/charts"
+	"gin-app/problem"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultChartWidth  = 640
+	defaultChartHeight = 400
+	minChartSize       = 200
+	maxChartSize       = 2000
+)
+
+// getChart renders spending for the current workspace as an image, taking the
+// same month range and expense filters as the cash flow report.
+func getChart(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := currentUserID(c)
+	kind := c.DefaultQuery("type", charts.Pie)
+	if !slices.Contains(charts.Kinds, kind) {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Invalid chart type").
+			WithCode(problem.CodeValidationFailed).
+			WithField("type", "must be pie, bar or line"))
+		return
+	}
+	format := c.DefaultQuery("format", "svg")
+	if format != "svg" && format != "png" {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Invalid format").
+			WithCode(problem.CodeValidationFailed).
+			WithField("format", "must be svg or png"))
+		return
+	}
+	width, ok := chartSize(c, "width", defaultChartWidth)
+	if !ok {
+		return
+	}
+	height, ok := chartSize(c, "height", defaultChartHeight)
+	if !ok {
+		return
+	}
+	loc := userLocation(ctx, userID)
+	start, end, ok := monthRange(c, loc)
+	if !ok {
+		return
+	}
+	f, ok := expenseFilterFromQuery(c)
+	if !ok {
+		return
+	}
+
+	chart := charts.Chart{
+		Kind:     kind,
+		Width:    width,
+		Height:   height,
+		Subtitle: start.Format(monthLayout) + " to " + end.AddDate(0, -1, 0).Format(monthLayout),
+	}
+	switch kind {
+	case charts.Pie:
+		stats, err := categoryStats(ctx, f.Match(currentWorkspaceID(c)), start, end)
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Failed to compute chart")
+			return
+		}
+		chart.Title = "Spending by category"
+		for _, stat := range stats {
+			chart.Slices = append(chart.Slices, charts.Slice{Label: stat.Category, Value: stat.Total})
+		}
+		chart.Slices = charts.FoldSlices(chart.Slices)
+	default:
+		months, err := cashFlow(ctx, incomeOwner(c), currentWorkspaceID(c), f, loc, start, end)
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Failed to compute chart")
+			return
+		}
+		expenses := charts.Series{Name: "Expenses"}
+		income := charts.Series{Name: "Income"}
+		for _, m := range months {
+			chart.Labels = append(chart.Labels, m.Month)
+			expenses.Values = append(expenses.Values, m.Expenses)
+			income.Values = append(income.Values, m.Income)
+		}
+		if kind == charts.Bar {
+			chart.Title = "Monthly spending"
+			chart.Series = []charts.Series{expenses}
+		} else {
+			chart.Title = "Income and expenses"
+			chart.Series = []charts.Series{expenses, income}
+		}
+	}
+
+	if format == "png" {
+		img, err := charts.RenderPNG(chart)
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Failed to render chart")
+			return
+		}
+		c.Data(http.StatusOK, "image/png", img)
+		return
+	}
+	c.Data(http.StatusOK, "image/svg+xml", charts.RenderSVG(chart))
+}
+
+func chartSize(c *gin.Context, param string, fallback int) (int, bool) {
+	v := c.Query(param)
+	if v == "" {
+		return fallback, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < minChartSize || n > maxChartSize {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Invalid chart size").
+			WithCode(problem.CodeValidationFailed).
+			WithField(param, "must be between 200 and 2000"))
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,275 @@
+// Package charts draws spending charts as SVG or PNG. Both outputs are
+// rendered from the same laid-out shapes, so they match pixel for pixel apart
+// from font hinting.
+package charts
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const (
+	Pie  = "pie"
+	Bar  = "bar"
+	Line = "line"
+
+	// MaxSlices caps the pie legend; smaller categories are folded into
+	// "Other".
+	MaxSlices = 8
+)
+
+var Kinds = []string{Pie, Bar, Line}
+
+var palette = []color.RGBA{
+	{0x4e, 0x79, 0xa7, 0xff},
+	{0xf2, 0x8e, 0x2b, 0xff},
+	{0xe1, 0x57, 0x59, 0xff},
+	{0x76, 0xb7, 0xb2, 0xff},
+	{0x59, 0xa1, 0x4f, 0xff},
+	{0xed, 0xc9, 0x48, 0xff},
+	{0xb0, 0x7a, 0xa1, 0xff},
+	{0xff, 0x9d, 0xa7, 0xff},
+	{0x9c, 0x75, 0x5f, 0xff},
+}
+
+var (
+	background = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	ink        = color.RGBA{0x33, 0x33, 0x33, 0xff}
+	muted      = color.RGBA{0x77, 0x77, 0x77, 0xff}
+	grid       = color.RGBA{0xe5, 0xe5, 0xe5, 0xff}
+)
+
+type Slice struct {
+	Label string
+	Value float64
+}
+
+type Series struct {
+	Name   string
+	Values []float64
+}
+
+// Chart describes what to draw. Pie charts use Slices; bar and line charts
+// plot each Series against Labels.
+type Chart struct {
+	Kind     string
+	Title    string
+	Subtitle string
+	Width    int
+	Height   int
+	Slices   []Slice
+	Labels   []string
+	Series   []Series
+}
+
+// FoldSlices sorts slices largest first and merges everything past
+// MaxSlices-1 into a single "Other" slice. Non-positive values are dropped.
+func FoldSlices(slices []Slice) []Slice {
+	var kept []Slice
+	for _, s := range slices {
+		if s.Value > 0 {
+			kept = append(kept, s)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Value > kept[j].Value })
+	if len(kept) <= MaxSlices {
+		return kept
+	}
+	other := Slice{Label: "Other"}
+	for _, s := range kept[MaxSlices-1:] {
+		other.Value += s.Value
+	}
+	return append(kept[:MaxSlices-1], other)
+}
+
+type anchor int
+
+const (
+	anchorStart anchor = iota
+	anchorMiddle
+	anchorEnd
+)
+
+type rect struct {
+	x, y, w, h float64
+	fill       color.RGBA
+}
+
+// wedge is a pie slice; a full turn draws a circle.
+type wedge struct {
+	cx, cy, r, from, to float64
+	fill                color.RGBA
+}
+
+type polyline struct {
+	points [][2]float64
+	width  float64
+	stroke color.RGBA
+}
+
+type text struct {
+	x, y   float64
+	size   float64
+	anchor anchor
+	fill   color.RGBA
+	value  string
+}
+
+// drawing is the laid-out chart; renderers paint its shapes in order.
+type drawing struct {
+	width, height int
+	rects         []rect
+	wedges        []wedge
+	lines         []polyline
+	texts         []text
+}
+
+func (d *drawing) label(x, y, size float64, a anchor, fill color.RGBA, value string) {
+	d.texts = append(d.texts, text{x: x, y: y, size: size, anchor: a, fill: fill, value: value})
+}
+
+func layout(c Chart) drawing {
+	d := drawing{width: c.Width, height: c.Height}
+	w, h := float64(c.Width), float64(c.Height)
+	d.rects = append(d.rects, rect{0, 0, w, h, background})
+	d.label(w/2, 24, 16, anchorMiddle, ink, c.Title)
+	if c.Subtitle != "" {
+		d.label(w/2, 42, 11, anchorMiddle, muted, c.Subtitle)
+	}
+	if c.empty() {
+		d.label(w/2, h/2, 13, anchorMiddle, muted, "No expenses in this period")
+		return d
+	}
+	switch c.Kind {
+	case Pie:
+		layoutPie(&d, c)
+	default:
+		layoutAxes(&d, c)
+	}
+	return d
+}
+
+func (c Chart) empty() bool {
+	if c.Kind == Pie {
+		return len(c.Slices) == 0
+	}
+	for _, s := range c.Series {
+		for _, v := range s.Values {
+			if v != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func layoutPie(d *drawing, c Chart) {
+	w, h := float64(c.Width), float64(c.Height)
+	top := 56.0
+	r := math.Min(w*0.27, (h-top-16)/2)
+	cx, cy := w*0.32, top+(h-top)/2
+
+	var total float64
+	for _, s := range c.Slices {
+		total += s.Value
+	}
+	angle := -math.Pi / 2
+	legendX := w * 0.62
+	legendY := cy - float64(len(c.Slices))*20/2 + 10
+	for i, s := range c.Slices {
+		fill := palette[i%len(palette)]
+		sweep := s.Value / total * 2 * math.Pi
+		d.wedges = append(d.wedges, wedge{cx, cy, r, angle, angle + sweep, fill})
+		angle += sweep
+
+		y := legendY + float64(i)*20
+		d.rects = append(d.rects, rect{legendX, y - 10, 12, 12, fill})
+		d.label(legendX+18, y, 12, anchorStart, ink, fmt.Sprintf("%s  %s (%.0f%%)", s.Label, formatAmount(s.Value), s.Value/total*100))
+	}
+}
+
+func layoutAxes(d *drawing, c Chart) {
+	w, h := float64(c.Width), float64(c.Height)
+	left, right, top, bottom := 64.0, w-20, 60.0, h-40
+	if len(c.Series) > 1 {
+		bottom -= 20
+	}
+	var max float64
+	for _, s := range c.Series {
+		for _, v := range s.Values {
+			max = math.Max(max, v)
+		}
+	}
+	step := niceStep(max / 5)
+	max = math.Ceil(max/step) * step
+	y := func(v float64) float64 { return bottom - v/max*(bottom-top) }
+
+	for v := 0.0; v <= max+step/2; v += step {
+		d.rects = append(d.rects, rect{left, y(v) - 0.5, right - left, 1, grid})
+		d.label(left-8, y(v)+4, 11, anchorEnd, muted, formatAmount(v))
+	}
+	d.rects = append(d.rects, rect{left, bottom - 0.5, right - left, 1, muted})
+
+	n := len(c.Labels)
+	slot := (right - left) / float64(n)
+	every := int(math.Ceil(float64(n) * 60 / (right - left)))
+	for i, l := range c.Labels {
+		if i%every == 0 {
+			d.label(left+slot*(float64(i)+0.5), bottom+16, 11, anchorMiddle, muted, l)
+		}
+	}
+
+	for si, s := range c.Series {
+		fill := palette[si%len(palette)]
+		if c.Kind == Bar {
+			group := slot * 0.7
+			bw := group / float64(len(c.Series))
+			for i, v := range s.Values {
+				x := left + slot*float64(i) + (slot-group)/2 + bw*float64(si)
+				d.rects = append(d.rects, rect{x, y(v), bw, bottom - y(v), fill})
+			}
+			continue
+		}
+		line := polyline{width: 2, stroke: fill}
+		for i, v := range s.Values {
+			p := [2]float64{left + slot*(float64(i)+0.5), y(v)}
+			line.points = append(line.points, p)
+			d.wedges = append(d.wedges, wedge{p[0], p[1], 3, 0, 2 * math.Pi, fill})
+		}
+		d.lines = append(d.lines, line)
+	}
+
+	if len(c.Series) > 1 {
+		x := left
+		for si, s := range c.Series {
+			d.rects = append(d.rects, rect{x, h - 30, 12, 12, palette[si%len(palette)]})
+			d.label(x+18, h-20, 12, anchorStart, ink, s.Name)
+			x += 30 + float64(len(s.Name))*7
+		}
+	}
+}
+
+// niceStep rounds a raw tick interval up to 1, 2, 2.5 or 5 times a power of
+// ten so axis labels stay readable.
+func niceStep(raw float64) float64 {
+	if raw <= 0 {
+		return 1
+	}
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 2.5, 5, 10} {
+		if raw <= m*mag {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+func formatAmount(v float64) string {
+	if v == math.Trunc(v) {
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
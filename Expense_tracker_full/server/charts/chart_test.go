@@ -0,0 +1,165 @@
+package charts
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestLayoutPie(t *testing.T) {
+	many := make([]Slice, len(palette)+2)
+	for i := range many {
+		many[i] = Slice{Label: fmt.Sprintf("Category %d", i), Value: float64(i + 1)}
+	}
+	tests := []struct {
+		name   string
+		slices []Slice
+		legend []string
+	}{
+		{name: "no slices"},
+		{name: "one slice", slices: []Slice{{Label: "Food", Value: 12.5}}, legend: []string{"Food  12.50 (100%)"}},
+		{name: "two slices", slices: []Slice{{Label: "Rent", Value: 750}, {Label: "Food", Value: 250}}, legend: []string{"Rent  750 (75%)", "Food  250 (25%)"}},
+		{name: "more slices than colours", slices: many},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := layout(Chart{Kind: Pie, Title: "Spending", Width: 600, Height: 400, Slices: tt.slices})
+			if d.width != 600 || d.height != 400 {
+				t.Errorf("size %dx%d, want 600x400", d.width, d.height)
+			}
+			if len(d.rects) == 0 || d.rects[0] != (rect{0, 0, 600, 400, background}) {
+				t.Error("drawing doesn't start with the background")
+			}
+			if len(d.texts) == 0 || d.texts[0].value != "Spending" {
+				t.Error("drawing has no title")
+			}
+			if len(tt.slices) == 0 {
+				if len(d.wedges) != 0 || d.texts[len(d.texts)-1].value != "No expenses in this period" {
+					t.Errorf("empty chart drew %d wedges, last text %q", len(d.wedges), d.texts[len(d.texts)-1].value)
+				}
+				return
+			}
+			if len(d.wedges) != len(tt.slices) {
+				t.Fatalf("drew %d wedges for %d slices", len(d.wedges), len(tt.slices))
+			}
+			// Wedges run clockwise from twelve o'clock, each starting where
+			// the last ended, and together make a full turn.
+			from := -math.Pi / 2
+			for i, w := range d.wedges {
+				if math.Abs(w.from-from) > 1e-9 || w.to < w.from {
+					t.Errorf("wedge %d spans %v to %v, want it to start at %v", i, w.from, w.to, from)
+				}
+				if w.fill != palette[i%len(palette)] {
+					t.Errorf("wedge %d fill %v, want palette colour %d", i, w.fill, i%len(palette))
+				}
+				if w.r <= 0 {
+					t.Errorf("wedge %d radius %v", i, w.r)
+				}
+				from = w.to
+			}
+			if math.Abs(from-3*math.Pi/2) > 1e-9 {
+				t.Errorf("wedges end at %v, want a full turn", from)
+			}
+			var legend []string
+			for _, txt := range d.texts[1:] {
+				legend = append(legend, txt.value)
+			}
+			if len(legend) != len(tt.slices) {
+				t.Fatalf("legend %q, want one entry per slice", legend)
+			}
+			for i, want := range tt.legend {
+				if legend[i] != want {
+					t.Errorf("legend %d = %q, want %q", i, legend[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLayoutAxes(t *testing.T) {
+	tests := []struct {
+		name   string
+		kind   string
+		labels []string
+		series []Series
+		empty  bool
+	}{
+		{name: "all zero", kind: Bar, labels: []string{"Jan", "Feb"}, series: []Series{{Name: "Spent", Values: []float64{0, 0}}}, empty: true},
+		{name: "no series", kind: Line, labels: []string{"Jan"}, empty: true},
+		{name: "one bar", kind: Bar, labels: []string{"Jan"}, series: []Series{{Name: "Spent", Values: []float64{42}}}},
+		{name: "grouped bars", kind: Bar, labels: []string{"Jan", "Feb", "Mar"}, series: []Series{
+			{Name: "Spent", Values: []float64{10, 20, 30}},
+			{Name: "Budget", Values: []float64{25, 25, 25}},
+		}},
+		{name: "line", kind: Line, labels: []string{"Jan", "Feb", "Mar"}, series: []Series{{Name: "Spent", Values: []float64{5, 0, 12}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := layout(Chart{Kind: tt.kind, Title: "Spending", Width: 600, Height: 400, Labels: tt.labels, Series: tt.series})
+			last := d.texts[len(d.texts)-1].value
+			if tt.empty {
+				if last != "No expenses in this period" || len(d.lines) != 0 || len(d.wedges) != 0 {
+					t.Errorf("empty chart drew lines %d, points %d, last text %q", len(d.lines), len(d.wedges), last)
+				}
+				return
+			}
+			for _, r := range d.rects {
+				if r.w < 0 || r.h < 0 || math.IsNaN(r.x) || math.IsNaN(r.y) {
+					t.Errorf("bad rect %+v", r)
+				}
+				if r.x < 0 || r.x+r.w > 600 || r.y < 0 || r.y+r.h > 400 {
+					t.Errorf("rect %+v outside the chart", r)
+				}
+			}
+			points := 0
+			for _, s := range tt.series {
+				points += len(s.Values)
+			}
+			if tt.kind == Line {
+				if len(d.lines) != len(tt.series) || len(d.wedges) != points {
+					t.Errorf("drew %d lines and %d points, want %d and %d", len(d.lines), len(d.wedges), len(tt.series), points)
+				}
+			} else if len(d.lines) != 0 {
+				t.Errorf("bar chart drew %d lines", len(d.lines))
+			}
+			var texts []string
+			for _, txt := range d.texts {
+				texts = append(texts, txt.value)
+			}
+			joined := strings.Join(texts, "|")
+			for _, l := range tt.labels {
+				if !strings.Contains(joined, "|"+l) {
+					t.Errorf("label %q missing from %q", l, joined)
+				}
+			}
+			if len(tt.series) > 1 {
+				for _, s := range tt.series {
+					if !strings.Contains(joined, "|"+s.Name) {
+						t.Errorf("legend entry %q missing from %q", s.Name, joined)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNiceStep(t *testing.T) {
+	tests := []struct{ raw, want float64 }{
+		{0, 1},
+		{-3, 1},
+		{0.7, 1},
+		{1, 1},
+		{1.5, 2},
+		{2.2, 2.5},
+		{3, 5},
+		{7, 10},
+		{130, 200},
+		{2400, 2500},
+	}
+	for _, tt := range tests {
+		if got := niceStep(tt.raw); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("niceStep(%v) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package charts
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+var (
+	fontOnce sync.Once
+	regular  *opentype.Font
+	fontErr  error
+)
+
+func loadFont() (*opentype.Font, error) {
+	fontOnce.Do(func() { regular, fontErr = opentype.Parse(goregular.TTF) })
+	return regular, fontErr
+}
+
+// RenderPNG rasterises the same drawing RenderSVG produces.
+func RenderPNG(c Chart) ([]byte, error) {
+	f, err := loadFont()
+	if err != nil {
+		return nil, err
+	}
+	d := layout(c)
+	img := image.NewRGBA(image.Rect(0, 0, d.width, d.height))
+	r := vector.NewRasterizer(d.width, d.height)
+	fill := func(col color.RGBA) {
+		r.Draw(img, img.Bounds(), image.NewUniform(col), image.Point{})
+		r.Reset(d.width, d.height)
+	}
+
+	for _, rc := range d.rects {
+		r.MoveTo(float32(rc.x), float32(rc.y))
+		r.LineTo(float32(rc.x+rc.w), float32(rc.y))
+		r.LineTo(float32(rc.x+rc.w), float32(rc.y+rc.h))
+		r.LineTo(float32(rc.x), float32(rc.y+rc.h))
+		r.ClosePath()
+		fill(rc.fill)
+	}
+	for _, l := range d.lines {
+		for i := 1; i < len(l.points); i++ {
+			segment(r, l.points[i-1], l.points[i], l.width)
+		}
+		fill(l.stroke)
+	}
+	for _, w := range d.wedges {
+		arc(r, w)
+		fill(w.fill)
+	}
+
+	faces := map[float64]font.Face{}
+	defer func() {
+		for _, face := range faces {
+			face.Close()
+		}
+	}()
+	for _, t := range d.texts {
+		face, ok := faces[t.size]
+		if !ok {
+			face, err = opentype.NewFace(f, &opentype.FaceOptions{Size: t.size, DPI: 72, Hinting: font.HintingFull})
+			if err != nil {
+				return nil, err
+			}
+			faces[t.size] = face
+		}
+		drawer := &font.Drawer{Dst: img, Src: image.NewUniform(t.fill), Face: face}
+		x := fixed.Int26_6(t.x * 64)
+		switch t.anchor {
+		case anchorMiddle:
+			x -= drawer.MeasureString(t.value) / 2
+		case anchorEnd:
+			x -= drawer.MeasureString(t.value)
+		}
+		drawer.Dot = fixed.Point26_6{X: x, Y: fixed.Int26_6(t.y * 64)}
+		drawer.DrawString(t.value)
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// segment adds a line of the given width from a to b as a quad, with a small
+// square cap so consecutive segments join without gaps.
+func segment(r *vector.Rasterizer, a, b [2]float64, width float64) {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	n := math.Hypot(dx, dy)
+	if n == 0 {
+		return
+	}
+	h := width / 2
+	ux, uy := dx/n*h, dy/n*h
+	px, py := -uy, ux
+	r.MoveTo(float32(a[0]-ux+px), float32(a[1]-uy+py))
+	r.LineTo(float32(b[0]+ux+px), float32(b[1]+uy+py))
+	r.LineTo(float32(b[0]+ux-px), float32(b[1]+uy-py))
+	r.LineTo(float32(a[0]-ux-px), float32(a[1]-uy-py))
+	r.ClosePath()
+}
+
+func arc(r *vector.Rasterizer, w wedge) {
+	full := w.to-w.from >= 2*math.Pi-1e-9
+	steps := int(math.Ceil((w.to - w.from) / (math.Pi / 90)))
+	if steps < 2 {
+		steps = 2
+	}
+	if full {
+		r.MoveTo(float32(w.cx+w.r*math.Cos(w.from)), float32(w.cy+w.r*math.Sin(w.from)))
+	} else {
+		r.MoveTo(float32(w.cx), float32(w.cy))
+	}
+	for i := 0; i <= steps; i++ {
+		a := w.from + (w.to-w.from)*float64(i)/float64(steps)
+		r.LineTo(float32(w.cx+w.r*math.Cos(a)), float32(w.cy+w.r*math.Sin(a)))
+	}
+	r.ClosePath()
+}
@@ -0,0 +1,64 @@
+package charts
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"math"
+)
+
+const fontFamily = "Go, Helvetica, Arial, sans-serif"
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// RenderSVG draws the chart as a standalone SVG document.
+func RenderSVG(c Chart) []byte {
+	d := layout(c)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="%s">`+"\n",
+		d.width, d.height, d.width, d.height, fontFamily)
+	for _, r := range d.rects {
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`+"\n", r.x, r.y, r.w, r.h, hex(r.fill))
+	}
+	for _, l := range d.lines {
+		b.WriteString(`<polyline fill="none" stroke-linejoin="round" stroke-linecap="round" points="`)
+		for i, p := range l.points {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			fmt.Fprintf(&b, "%.1f,%.1f", p[0], p[1])
+		}
+		fmt.Fprintf(&b, `" stroke="%s" stroke-width="%.1f"/>`+"\n", hex(l.stroke), l.width)
+	}
+	for _, w := range d.wedges {
+		if w.to-w.from >= 2*math.Pi-1e-9 {
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="%.1f" fill="%s"/>`+"\n", w.cx, w.cy, w.r, hex(w.fill))
+			continue
+		}
+		x0, y0 := w.cx+w.r*math.Cos(w.from), w.cy+w.r*math.Sin(w.from)
+		x1, y1 := w.cx+w.r*math.Cos(w.to), w.cy+w.r*math.Sin(w.to)
+		large := 0
+		if w.to-w.from > math.Pi {
+			large = 1
+		}
+		fmt.Fprintf(&b, `<path d="M%.1f,%.1f L%.1f,%.1f A%.1f,%.1f 0 %d 1 %.1f,%.1f Z" fill="%s" stroke="#ffffff" stroke-width="1"/>`+"\n",
+			w.cx, w.cy, x0, y0, w.r, w.r, large, x1, y1, hex(w.fill))
+	}
+	for _, t := range d.texts {
+		a := "start"
+		switch t.anchor {
+		case anchorMiddle:
+			a = "middle"
+		case anchorEnd:
+			a = "end"
+		}
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" font-size="%.0f" text-anchor="%s" fill="%s">`, t.x, t.y, t.size, a, hex(t.fill))
+		xml.EscapeText(&b, []byte(t.value))
+		b.WriteString("</text>\n")
+	}
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
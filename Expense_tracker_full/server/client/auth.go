@@ -0,0 +1,25 @@
+// Package client is a typed Go client for the expense server. Everything in
+// client.gen.go is generated from api/openapi.yaml; run `go generate ./api`
+// after changing the document.
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// WithBearerToken authenticates every request with a session token from
+// /login, or with an API key passed as a bearer token.
+func WithBearerToken(token string) ClientOption {
+	return WithRequestEditorFn(func(_ context.Context, req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+func WithAPIKey(key string) ClientOption {
+	return WithRequestEditorFn(func(_ context.Context, req *http.Request) error {
+		req.Header.Set("X-API-Key", key)
+		return nil
+	})
+}
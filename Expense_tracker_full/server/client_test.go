@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	apiclient "gin-app/client"
+	"net/http"
+	"strings"
+	"testing"
+
+	openapi_types "github.com/oapi-codegen/runtime/types"
+)
+
+// signedInClient signs up and logs in through the generated client,
+// returning one that sends the session token.
+func signedInClient(t *testing.T, server string) *apiclient.ClientWithResponses {
+	t.Helper()
+	ctx := context.Background()
+	anon, err := apiclient.NewClientWithResponses(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const email, password = "client@example.com", "correct-horse"
+	signup, err := anon.SignupWithResponse(ctx, apiclient.SignupRequest{
+		Email:           openapi_types.Email(email),
+		Password:        password,
+		ConfirmPassword: password,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signup.JSON200 == nil {
+		t.Fatalf("signup: %s: %s", signup.Status(), signup.Body)
+	}
+	login, err := anon.LoginWithResponse(ctx, apiclient.LoginRequest{Email: email, Password: password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.JSON200 == nil || login.JSON200.Token == "" {
+		t.Fatalf("login: %s: %s", login.Status(), login.Body)
+	}
+	authed, err := apiclient.NewClientWithResponses(server, apiclient.WithBearerToken(login.JSON200.Token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authed
+}
+
+func TestClientDecodesResponses(t *testing.T) {
+	app := testServer(t)
+	c := signedInClient(t, app.URL)
+	ctx := context.Background()
+
+	me, err := c.GetProfileWithResponse(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if me.JSON200 == nil || me.JSON200.Email != "client@example.com" || me.JSON200.DefaultWorkspaceId == nil {
+		t.Fatalf("GET /me: %s: %s", me.Status(), me.Body)
+	}
+
+	amount, category := 4.5, "Food"
+	created, err := c.CreateExpenseWithResponse(ctx, nil, apiclient.ExpenseInput{
+		Title:    "Coffee",
+		Amount:   &amount,
+		Category: &category,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.JSON201 == nil {
+		t.Fatalf("POST /expense: %s: %s", created.Status(), created.Body)
+	}
+	expense := *created.JSON201
+	if expense.Title != "Coffee" || expense.Amount != amount || expense.Revision != 1 || expense.WorkspaceId != *me.JSON200.DefaultWorkspaceId {
+		t.Fatalf("created expense = %+v", expense)
+	}
+
+	list, err := c.ListExpensesWithResponse(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.JSON200 == nil || len(*list.JSON200) != 1 || (*list.JSON200)[0].Id != expense.Id {
+		t.Fatalf("GET /expense: %s: %s", list.Status(), list.Body)
+	}
+
+	feed, err := c.PullChangesWithResponse(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.JSON200 == nil || len(feed.JSON200.Changes) != 1 || feed.JSON200.Changes[0].Id != expense.Id {
+		t.Fatalf("GET /sync: %s: %s", feed.Status(), feed.Body)
+	}
+}
+
+func TestClientSeesValidationProblems(t *testing.T) {
+	app := testServer(t)
+	c := signedInClient(t, app.URL)
+	ctx := context.Background()
+
+	created, err := c.CreateExpenseWithBodyWithResponse(ctx, nil, "application/json", strings.NewReader(`{"title": 5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.StatusCode() != http.StatusBadRequest || created.ApplicationproblemJSON400 == nil {
+		t.Fatalf("POST /expense with a numeric title: %s: %s", created.Status(), created.Body)
+	}
+	if p := created.ApplicationproblemJSON400; p.Code != "validation_failed" || p.Errors == nil || len(*p.Errors) == 0 {
+		t.Fatalf("problem = %+v, want validation_failed with field errors", p)
+	}
+
+	limit := 5000
+	feed, err := c.PullChangesWithResponse(ctx, &apiclient.PullChangesParams{Limit: &limit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.StatusCode() != http.StatusBadRequest || feed.ApplicationproblemJSON400 == nil {
+		t.Fatalf("GET /sync?limit=5000: %s: %s", feed.Status(), feed.Body)
+	}
+	if p := feed.ApplicationproblemJSON400; p.Code != "validation_failed" {
+		t.Fatalf("problem = %+v, want validation_failed", p)
+	}
+}
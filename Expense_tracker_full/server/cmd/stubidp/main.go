@@ -0,0 +1,37 @@
+// Command stubidp is a minimal OpenID Connect provider for exercising the
+// expense server's single sign-on flow locally. It approves every
+// authorization request for a single configured user.
+//
+//	STUB_IDP_ADDR=:9000 STUB_IDP_EMAIL=dev@example.com go run ./cmd/stubidp
+//
+// Point the server at it with OIDC_ISSUER=http://localhost:9000.
+package main
+
+import (
+	"gin-app/stubidp"
+	"log"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+func main() {
+	addr := getenv("STUB_IDP_ADDR", ":9000")
+	p, err := stubidp.New(
+		getenv("STUB_IDP_ISSUER", "http://localhost"+addr),
+		getenv("STUB_IDP_EMAIL", "dev@example.com"),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r := gin.Default()
+	p.Routes(r)
+	r.Run(addr)
+}
+
+func getenv(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,490 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Server     ServerConfig     `yaml:"server"`
+	Mongo      MongoConfig      `yaml:"mongo"`
+	CORS       CORSConfig       `yaml:"cors"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Events     EventsConfig     `yaml:"events"`
+	Statements StatementsConfig `yaml:"statements"`
+	Anomalies  AnomaliesConfig  `yaml:"anomalies"`
+	Workspaces WorkspacesConfig `yaml:"workspaces"`
+	Retention  RetentionConfig  `yaml:"retention"`
+	Encryption EncryptionConfig `yaml:"encryption"`
+}
+
+type ServerConfig struct {
+	ListenAddr      string        `yaml:"listen_addr"`
+	TLSCertFile     string        `yaml:"tls_cert_file"`
+	TLSKeyFile      string        `yaml:"tls_key_file"`
+	RequestTimeout  time.Duration `yaml:"request_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type MongoConfig struct {
+	URI            string        `yaml:"uri"`
+	Database       string        `yaml:"database"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	MaxPoolSize    uint64        `yaml:"max_pool_size"`
+	Collections    Collections   `yaml:"collections"`
+}
+
+type Collections struct {
+	Expenses      string `yaml:"expenses"`
+	Users         string `yaml:"users"`
+	Sessions      string `yaml:"sessions"`
+	APIKeys       string `yaml:"api_keys"`
+	OIDCState     string `yaml:"oidc_state"`
+	Income        string `yaml:"income"`
+	Goals         string `yaml:"goals"`
+	SavedSearches string `yaml:"saved_searches"`
+	Statements    string `yaml:"statements"`
+	Anomalies     string `yaml:"anomalies"`
+	CategoryStats string `yaml:"category_stats"`
+	Workspaces    string `yaml:"workspaces"`
+	Invitations   string `yaml:"invitations"`
+	Tombstones    string `yaml:"tombstones"`
+	Merchants     string `yaml:"merchants"`
+}
+
+// EventsConfig controls the /events stream. With ChangeStreams set the hub is
+// fed from a Mongo change stream so every instance sees every write; that
+// needs a replica set, and delete events also need pre-images enabled on the
+// expenses collection.
+type EventsConfig struct {
+	ChangeStreams bool          `yaml:"change_streams"`
+	Heartbeat     time.Duration `yaml:"heartbeat"`
+}
+
+// StatementsConfig controls the monthly statement scheduler. Delivery names
+// the statements.Deliverer to use; only "filesystem" exists today.
+type StatementsConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Delivery      string        `yaml:"delivery"`
+	Dir           string        `yaml:"dir"`
+	CheckInterval time.Duration `yaml:"check_interval"`
+	TopExpenses   int           `yaml:"top_expenses"`
+}
+
+// AnomaliesConfig tunes expense anomaly detection. An amount is an outlier
+// when it is at least ZThreshold standard deviations from its category mean,
+// once the category has MinSamples expenses.
+type AnomaliesConfig struct {
+	ZThreshold      float64       `yaml:"z_threshold"`
+	MinSamples      int           `yaml:"min_samples"`
+	DuplicateWindow time.Duration `yaml:"duplicate_window"`
+}
+
+type WorkspacesConfig struct {
+	InvitationTTL time.Duration `yaml:"invitation_ttl"`
+}
+
+const (
+	RetentionPurge     = "purge"
+	RetentionAnonymise = "anonymise"
+)
+
+// RetentionConfig controls what happens to deleted accounts. Deletion only
+// disables an account; once GracePeriod has passed the retention job either
+// purges everything or anonymises the profile and keeps the financial
+// records, depending on Policy.
+type RetentionConfig struct {
+	Policy        string        `yaml:"policy"`
+	GracePeriod   time.Duration `yaml:"grace_period"`
+	CheckInterval time.Duration `yaml:"check_interval"`
+}
+
+// EncryptionConfig holds the key-encryption keys for field-level encryption,
+// base64-encoded 32-byte AES keys by ID. Encryption is off until ActiveKey is
+// set. To rotate, add a key, make it active and keep the old one until the
+// rotation job has rewrapped everything sealed with it.
+type EncryptionConfig struct {
+	Keys             map[string]string `yaml:"keys"`
+	ActiveKey        string            `yaml:"active_key"`
+	RotationInterval time.Duration     `yaml:"rotation_interval"`
+}
+
+func (e EncryptionConfig) Enabled() bool {
+	return e.ActiveKey != ""
+}
+
+// DecodedKeys returns the configured keys as raw bytes.
+func (e EncryptionConfig) DecodedKeys() (map[string][]byte, error) {
+	keys := make(map[string][]byte, len(e.Keys))
+	for id, encoded := range e.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %q must be 32 bytes, got %d", id, len(key))
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+type AuthConfig struct {
+	SessionTTL  time.Duration `yaml:"session_ttl"`
+	AdminEmails []string      `yaml:"admin_emails"`
+	OIDC        OIDCConfig    `yaml:"oidc"`
+}
+
+type OIDCConfig struct {
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	RedirectURL  string `yaml:"redirect_url"`
+	PostLoginURL string `yaml:"post_login_url"`
+}
+
+func (c CORSConfig) AllowAll() bool {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s ServerConfig) TLSEnabled() bool {
+	return s.TLSCertFile != "" && s.TLSKeyFile != ""
+}
+
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			ListenAddr:      ":5000",
+			RequestTimeout:  10 * time.Second,
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Mongo: MongoConfig{
+			Database:       "Expense_Tracker",
+			ConnectTimeout: 10 * time.Second,
+			Collections: Collections{
+				Expenses:      "expense_data",
+				Users:         "user_data",
+				Sessions:      "session_data",
+				APIKeys:       "api_keys",
+				OIDCState:     "oidc_state",
+				Income:        "income_data",
+				Goals:         "savings_goals",
+				SavedSearches: "saved_searches",
+				Statements:    "statement_runs",
+				Anomalies:     "expense_anomalies",
+				CategoryStats: "category_stats",
+				Workspaces:    "workspaces",
+				Invitations:   "workspace_invitations",
+				Tombstones:    "expense_tombstones",
+				Merchants:     "merchants",
+			},
+		},
+		CORS:   CORSConfig{AllowedOrigins: []string{"*"}},
+		Auth:   AuthConfig{SessionTTL: 7 * 24 * time.Hour},
+		Events: EventsConfig{Heartbeat: 25 * time.Second},
+		Statements: StatementsConfig{
+			Delivery:      "filesystem",
+			Dir:           "data/statements",
+			CheckInterval: time.Hour,
+			TopExpenses:   10,
+		},
+		Anomalies: AnomaliesConfig{
+			ZThreshold:      3,
+			MinSamples:      5,
+			DuplicateWindow: 10 * time.Minute,
+		},
+		Workspaces: WorkspacesConfig{InvitationTTL: 7 * 24 * time.Hour},
+		Retention: RetentionConfig{
+			Policy:        RetentionPurge,
+			GracePeriod:   30 * 24 * time.Hour,
+			CheckInterval: time.Hour,
+		},
+		Encryption: EncryptionConfig{RotationInterval: time.Hour},
+	}
+}
+
+// Load layers configuration as defaults, then the YAML file named by
+// CONFIG_FILE (or ./config.yaml when present), then .env, then the process
+// environment, so a deploy can override any single value with a variable.
+func Load() (Config, error) {
+	cfg := Default()
+
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		if _, err := os.Stat("config.yaml"); err == nil {
+			path = "config.yaml"
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("parse config file %s: %w", path, err)
+		}
+	}
+
+	_ = godotenv.Load()
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+func applyEnv(cfg *Config) error {
+	envString("LISTEN_ADDR", &cfg.Server.ListenAddr)
+	envString("TLS_CERT_FILE", &cfg.Server.TLSCertFile)
+	envString("TLS_KEY_FILE", &cfg.Server.TLSKeyFile)
+	envString("MONGO_URI", &cfg.Mongo.URI)
+	envString("MONGO_DATABASE", &cfg.Mongo.Database)
+	envString("MONGO_EXPENSES_COLLECTION", &cfg.Mongo.Collections.Expenses)
+	envString("MONGO_USERS_COLLECTION", &cfg.Mongo.Collections.Users)
+	envString("MONGO_SESSIONS_COLLECTION", &cfg.Mongo.Collections.Sessions)
+	envString("MONGO_API_KEYS_COLLECTION", &cfg.Mongo.Collections.APIKeys)
+	envString("MONGO_OIDC_STATE_COLLECTION", &cfg.Mongo.Collections.OIDCState)
+	envString("MONGO_INCOME_COLLECTION", &cfg.Mongo.Collections.Income)
+	envString("MONGO_GOALS_COLLECTION", &cfg.Mongo.Collections.Goals)
+	envString("MONGO_SAVED_SEARCHES_COLLECTION", &cfg.Mongo.Collections.SavedSearches)
+	envString("MONGO_STATEMENTS_COLLECTION", &cfg.Mongo.Collections.Statements)
+	envString("MONGO_ANOMALIES_COLLECTION", &cfg.Mongo.Collections.Anomalies)
+	envString("MONGO_CATEGORY_STATS_COLLECTION", &cfg.Mongo.Collections.CategoryStats)
+	envString("MONGO_WORKSPACES_COLLECTION", &cfg.Mongo.Collections.Workspaces)
+	envString("MONGO_INVITATIONS_COLLECTION", &cfg.Mongo.Collections.Invitations)
+	envString("MONGO_TOMBSTONES_COLLECTION", &cfg.Mongo.Collections.Tombstones)
+	envString("MONGO_MERCHANTS_COLLECTION", &cfg.Mongo.Collections.Merchants)
+	envList("CORS_ALLOWED_ORIGINS", &cfg.CORS.AllowedOrigins)
+	envList("ADMIN_EMAILS", &cfg.Auth.AdminEmails)
+	envString("OIDC_ISSUER", &cfg.Auth.OIDC.Issuer)
+	envString("OIDC_CLIENT_ID", &cfg.Auth.OIDC.ClientID)
+	envString("OIDC_CLIENT_SECRET", &cfg.Auth.OIDC.ClientSecret)
+	envString("OIDC_REDIRECT_URL", &cfg.Auth.OIDC.RedirectURL)
+	envString("OIDC_POST_LOGIN_URL", &cfg.Auth.OIDC.PostLoginURL)
+	envString("STATEMENTS_DELIVERY", &cfg.Statements.Delivery)
+	envString("STATEMENTS_DIR", &cfg.Statements.Dir)
+	envString("RETENTION_POLICY", &cfg.Retention.Policy)
+	envString("ENCRYPTION_ACTIVE_KEY", &cfg.Encryption.ActiveKey)
+	if v, ok := os.LookupEnv("ENCRYPTION_KEYS"); ok {
+		keys, err := parseKeys(v)
+		if err != nil {
+			return fmt.Errorf("ENCRYPTION_KEYS: %w", err)
+		}
+		cfg.Encryption.Keys = keys
+	}
+
+	durations := map[string]*time.Duration{
+		"REQUEST_TIMEOUT":              &cfg.Server.RequestTimeout,
+		"SHUTDOWN_TIMEOUT":             &cfg.Server.ShutdownTimeout,
+		"MONGO_CONNECT_TIMEOUT":        &cfg.Mongo.ConnectTimeout,
+		"SESSION_TTL":                  &cfg.Auth.SessionTTL,
+		"EVENTS_HEARTBEAT":             &cfg.Events.Heartbeat,
+		"STATEMENTS_INTERVAL":          &cfg.Statements.CheckInterval,
+		"ANOMALY_DUPLICATE_WINDOW":     &cfg.Anomalies.DuplicateWindow,
+		"INVITATION_TTL":               &cfg.Workspaces.InvitationTTL,
+		"RETENTION_GRACE_PERIOD":       &cfg.Retention.GracePeriod,
+		"RETENTION_INTERVAL":           &cfg.Retention.CheckInterval,
+		"ENCRYPTION_ROTATION_INTERVAL": &cfg.Encryption.RotationInterval,
+	}
+	for name, dst := range durations {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = d
+		}
+	}
+	if v := os.Getenv("MONGO_MAX_POOL_SIZE"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("MONGO_MAX_POOL_SIZE: %w", err)
+		}
+		cfg.Mongo.MaxPoolSize = n
+	}
+	if v := os.Getenv("ANOMALY_Z_THRESHOLD"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("ANOMALY_Z_THRESHOLD: %w", err)
+		}
+		cfg.Anomalies.ZThreshold = f
+	}
+	if v := os.Getenv("ANOMALY_MIN_SAMPLES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("ANOMALY_MIN_SAMPLES: %w", err)
+		}
+		cfg.Anomalies.MinSamples = n
+	}
+	bools := map[string]*bool{
+		"EVENTS_CHANGE_STREAMS": &cfg.Events.ChangeStreams,
+		"STATEMENTS_ENABLED":    &cfg.Statements.Enabled,
+	}
+	for name, dst := range bools {
+		if v := os.Getenv(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = b
+		}
+	}
+	return nil
+}
+
+func envString(name string, dst *string) {
+	if v, ok := os.LookupEnv(name); ok {
+		*dst = strings.TrimSpace(v)
+	}
+}
+
+// parseKeys reads "id=base64key" pairs separated by commas.
+func parseKeys(v string) (map[string]string, error) {
+	keys := map[string]string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		id, key, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(id) == "" {
+			return nil, fmt.Errorf("expected id=key, got %q", item)
+		}
+		keys[strings.TrimSpace(id)] = strings.TrimSpace(key)
+	}
+	return keys, nil
+}
+
+func envList(name string, dst *[]string) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*dst = list
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	if c.Server.ListenAddr == "" {
+		errs = append(errs, errors.New("listen address must not be empty"))
+	}
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS requires both a certificate and a key file"))
+	}
+	for _, file := range []string{c.Server.TLSCertFile, c.Server.TLSKeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			errs = append(errs, fmt.Errorf("TLS file: %w", err))
+		}
+	}
+	if c.Server.RequestTimeout <= 0 || c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("request and shutdown timeouts must be positive"))
+	}
+	if c.Mongo.URI == "" {
+		errs = append(errs, errors.New("MONGO_URI not set in .env"))
+	}
+	if c.Mongo.Database == "" {
+		errs = append(errs, errors.New("mongo database must not be empty"))
+	}
+	if c.Mongo.ConnectTimeout <= 0 {
+		errs = append(errs, errors.New("mongo connect timeout must be positive"))
+	}
+	cols := c.Mongo.Collections
+	for _, name := range []string{cols.Expenses, cols.Users, cols.Sessions, cols.APIKeys, cols.OIDCState, cols.Income, cols.Goals, cols.SavedSearches, cols.Statements, cols.Anomalies, cols.CategoryStats, cols.Workspaces, cols.Invitations, cols.Tombstones, cols.Merchants} {
+		if name == "" {
+			errs = append(errs, errors.New("mongo collection names must not be empty"))
+			break
+		}
+	}
+	if len(c.CORS.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("at least one CORS origin is required; use * to allow all"))
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid CORS origin %q", origin))
+		}
+	}
+	if c.Auth.SessionTTL <= 0 {
+		errs = append(errs, errors.New("session TTL must be positive"))
+	}
+	if c.Events.Heartbeat <= 0 {
+		errs = append(errs, errors.New("events heartbeat must be positive"))
+	}
+	if c.Workspaces.InvitationTTL <= 0 {
+		errs = append(errs, errors.New("invitation TTL must be positive"))
+	}
+	if p := c.Retention.Policy; p != RetentionPurge && p != RetentionAnonymise {
+		errs = append(errs, fmt.Errorf("unknown retention policy %q", p))
+	}
+	if c.Retention.GracePeriod < 0 {
+		errs = append(errs, errors.New("retention grace period must not be negative"))
+	}
+	if c.Retention.CheckInterval <= 0 {
+		errs = append(errs, errors.New("retention check interval must be positive"))
+	}
+	if _, err := c.Encryption.DecodedKeys(); err != nil {
+		errs = append(errs, err)
+	}
+	if enc := c.Encryption; enc.Enabled() {
+		if _, ok := enc.Keys[enc.ActiveKey]; !ok {
+			errs = append(errs, fmt.Errorf("active encryption key %q is not configured", enc.ActiveKey))
+		}
+		if enc.RotationInterval <= 0 {
+			errs = append(errs, errors.New("encryption rotation interval must be positive"))
+		}
+	}
+	if c.Anomalies.ZThreshold <= 0 {
+		errs = append(errs, errors.New("anomaly z threshold must be positive"))
+	}
+	if c.Anomalies.MinSamples < 2 {
+		errs = append(errs, errors.New("anomaly min samples must be at least 2"))
+	}
+	if c.Anomalies.DuplicateWindow < 0 {
+		errs = append(errs, errors.New("anomaly duplicate window must not be negative"))
+	}
+	if st := c.Statements; st.Enabled {
+		if st.Delivery != "filesystem" {
+			errs = append(errs, fmt.Errorf("unknown statement delivery %q", st.Delivery))
+		}
+		if st.Delivery == "filesystem" && st.Dir == "" {
+			errs = append(errs, errors.New("filesystem statement delivery requires a directory"))
+		}
+		if st.CheckInterval <= 0 {
+			errs = append(errs, errors.New("statement check interval must be positive"))
+		}
+		if st.TopExpenses <= 0 {
+			errs = append(errs, errors.New("statement top expenses must be positive"))
+		}
+	}
+	if o := c.Auth.OIDC; o.Issuer != "" && (o.ClientID == "" || o.RedirectURL == "") {
+		errs = append(errs, errors.New("OIDC issuer requires a client ID and redirect URL"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"encoding/base64"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testKey  = base64.StdEncoding.EncodeToString(make([]byte, 32))
+	shortKey = base64.StdEncoding.EncodeToString(make([]byte, 16))
+)
+
+func validConfig() Config {
+	cfg := Default()
+	cfg.Mongo.URI = "mongodb://localhost:27017"
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   string
+	}{
+		{name: "defaults with a Mongo URI", change: func(c *Config) {}},
+		{name: "no Mongo URI", change: func(c *Config) { c.Mongo.URI = "" }, want: "MONGO_URI"},
+		{name: "empty listen address", change: func(c *Config) { c.Server.ListenAddr = "" }, want: "listen address"},
+		{name: "TLS certificate without a key", change: func(c *Config) { c.Server.TLSCertFile = "cert.pem" }, want: "both a certificate and a key"},
+		{name: "missing TLS files", change: func(c *Config) {
+			c.Server.TLSCertFile, c.Server.TLSKeyFile = "/nonexistent/cert.pem", "/nonexistent/key.pem"
+		}, want: "TLS file"},
+		{name: "zero request timeout", change: func(c *Config) { c.Server.RequestTimeout = 0 }, want: "timeouts must be positive"},
+		{name: "empty collection name", change: func(c *Config) { c.Mongo.Collections.Merchants = "" }, want: "collection names"},
+		{name: "no CORS origins", change: func(c *Config) { c.CORS.AllowedOrigins = nil }, want: "CORS origin is required"},
+		{name: "CORS origin without a scheme", change: func(c *Config) { c.CORS.AllowedOrigins = []string{"example.com"} }, want: `invalid CORS origin "example.com"`},
+		{name: "CORS origin", change: func(c *Config) { c.CORS.AllowedOrigins = []string{"https://app.example.com"} }},
+		{name: "unknown retention policy", change: func(c *Config) { c.Retention.Policy = "keep" }, want: `retention policy "keep"`},
+		{name: "anonymise retention", change: func(c *Config) { c.Retention.Policy = RetentionAnonymise }},
+		{name: "negative grace period", change: func(c *Config) { c.Retention.GracePeriod = -time.Hour }, want: "grace period"},
+		{name: "encryption enabled", change: func(c *Config) {
+			c.Encryption.Keys = map[string]string{"k1": testKey}
+			c.Encryption.ActiveKey = "k1"
+		}},
+		{name: "active key not configured", change: func(c *Config) {
+			c.Encryption.Keys = map[string]string{"k1": testKey}
+			c.Encryption.ActiveKey = "k2"
+		}, want: `active encryption key "k2"`},
+		{name: "short encryption key", change: func(c *Config) { c.Encryption.Keys = map[string]string{"k1": shortKey} }, want: "must be 32 bytes"},
+		{name: "encryption key not base64", change: func(c *Config) { c.Encryption.Keys = map[string]string{"k1": "not base64!"} }, want: `encryption key "k1"`},
+		{name: "no rotation interval", change: func(c *Config) {
+			c.Encryption.Keys = map[string]string{"k1": testKey}
+			c.Encryption.ActiveKey = "k1"
+			c.Encryption.RotationInterval = 0
+		}, want: "rotation interval"},
+		{name: "too few anomaly samples", change: func(c *Config) { c.Anomalies.MinSamples = 1 }, want: "min samples"},
+		{name: "statements without a directory", change: func(c *Config) {
+			c.Statements.Enabled = true
+			c.Statements.Dir = ""
+		}, want: "requires a directory"},
+		{name: "unknown statement delivery", change: func(c *Config) {
+			c.Statements.Enabled = true
+			c.Statements.Delivery = "email"
+		}, want: `statement delivery "email"`},
+		{name: "OIDC issuer without a client", change: func(c *Config) { c.Auth.OIDC.Issuer = "https://idp.example.com" }, want: "OIDC issuer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.change(&cfg)
+			err := cfg.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Validate = %v, want an error mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := validConfig()
+	cfg.Mongo.URI = ""
+	cfg.Auth.SessionTTL = 0
+	cfg.Anomalies.ZThreshold = 0
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate accepted an invalid config")
+	}
+	for _, want := range []string{"MONGO_URI", "session TTL", "z threshold"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  map[string]string
+		ok    bool
+	}{
+		{name: "one key", value: "k1=" + testKey, want: map[string]string{"k1": testKey}, ok: true},
+		{name: "several keys with spaces", value: " k1 = abc , k2=def ", want: map[string]string{"k1": "abc", "k2": "def"}, ok: true},
+		{name: "padding in the key", value: "k1=YQ==", want: map[string]string{"k1": "YQ=="}, ok: true},
+		{name: "empty items skipped", value: "k1=abc,,", want: map[string]string{"k1": "abc"}, ok: true},
+		{name: "empty", value: "", want: map[string]string{}, ok: true},
+		{name: "missing separator", value: "k1", ok: false},
+		{name: "missing ID", value: "=abc", ok: false},
+		{name: "one bad item", value: "k1=abc,k2", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseKeys(tt.value)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && !maps.Equal(got, tt.want) {
+				t.Errorf("parseKeys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncryptionKeysFromEnv(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEYS", "old="+testKey+", new="+testKey)
+	t.Setenv("ENCRYPTION_ACTIVE_KEY", " new ")
+	cfg := validConfig()
+	if err := applyEnv(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Encryption.ActiveKey != "new" || len(cfg.Encryption.Keys) != 2 {
+		t.Fatalf("active %q, keys %v", cfg.Encryption.ActiveKey, cfg.Encryption.Keys)
+	}
+	keys, err := cfg.Encryption.DecodedKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys["old"]) != 32 || len(keys["new"]) != 32 {
+		t.Errorf("decoded keys %v", keys)
+	}
+
+	t.Setenv("ENCRYPTION_KEYS", "new")
+	if err := applyEnv(&cfg); err == nil || !strings.Contains(err.Error(), "ENCRYPTION_KEYS") {
+		t.Errorf("applyEnv = %v, want an ENCRYPTION_KEYS error", err)
+	}
+}
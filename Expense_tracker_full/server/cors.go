@@ -0,0 +1,25 @@
+package main
+
+import (
+	"gin-app/config"
+	"gin-app/middleware"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+func corsMiddleware(c config.CORSConfig) gin.HandlerFunc {
+	corsConfig := cors.Config{
+		AllowMethods:  []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
+		AllowHeaders:  []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-API-Key", "X-Workspace-ID"},
+		ExposeHeaders: []string{"X-Text-Search-Scope", middleware.RequestIDHeader},
+		MaxAge:        12 * time.Hour,
+	}
+	if c.AllowAll() {
+		corsConfig.AllowAllOrigins = true
+	} else {
+		corsConfig.AllowOrigins = c.AllowedOrigins
+	}
+	return cors.New(corsConfig)
+}
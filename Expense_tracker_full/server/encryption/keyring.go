@@ -0,0 +1,162 @@
+// Package encryption seals selected document fields with envelope
+// encryption: every value gets its own random data key, which is stored next
+// to the ciphertext wrapped by a locally configured key-encryption key (KEK).
+// Rotating the KEK only rewraps the data keys.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+const KeySize = 32
+
+var ErrUnknownKey = errors.New("encryption: unknown key-encryption key")
+
+// Envelope is a sealed value as stored in Mongo. KeyID names the KEK that
+// wrapped the data key. Bound is set when the ciphertext was sealed with
+// additional data; values sealed before that was supported have none.
+type Envelope struct {
+	KeyID      string `bson:"k"`
+	WrappedKey []byte `bson:"w"`
+	Nonce      []byte `bson:"n"`
+	Ciphertext []byte `bson:"c"`
+	Bound      bool   `bson:"b,omitempty"`
+}
+
+// Keyring holds the KEKs by ID. New values are sealed with the active one;
+// the others are kept to open values sealed before a rotation.
+type Keyring struct {
+	active string
+	keks   map[string]cipher.AEAD
+}
+
+func NewKeyring(keys map[string][]byte, active string) (*Keyring, error) {
+	k := &Keyring{active: active, keks: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: key %q: %w", id, err)
+		}
+		k.keks[id] = aead
+	}
+	if _, ok := k.keks[active]; !ok {
+		return nil, fmt.Errorf("encryption: active key %q is not configured", active)
+	}
+	return k, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (k *Keyring) ActiveKey() string {
+	return k.active
+}
+
+// Seal encrypts plaintext under a new data key. aad, when not nil, is
+// authenticated with it and must be passed again to Open.
+func (k *Keyring) Seal(plaintext, aad []byte) (Envelope, error) {
+	dek := make([]byte, KeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return Envelope{}, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return Envelope{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return Envelope{}, err
+	}
+	wrapped, err := k.wrap(k.active, dek)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{
+		KeyID:      k.active,
+		WrappedKey: wrapped,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, aad),
+		Bound:      aad != nil,
+	}, nil
+}
+
+// Open decrypts e, checking aad if e was sealed with additional data.
+func (k *Keyring) Open(e Envelope, aad []byte) ([]byte, error) {
+	if !e.Bound {
+		aad = nil
+	}
+	dek, err := k.unwrap(e)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, e.Nonce, e.Ciphertext, aad)
+}
+
+// Rewrap moves e's data key to the active KEK, leaving the ciphertext alone.
+func (k *Keyring) Rewrap(e Envelope) (Envelope, error) {
+	if e.KeyID == k.active {
+		return e, nil
+	}
+	dek, err := k.unwrap(e)
+	if err != nil {
+		return e, err
+	}
+	wrapped, err := k.wrap(k.active, dek)
+	if err != nil {
+		return e, err
+	}
+	e.KeyID, e.WrappedKey = k.active, wrapped
+	return e, nil
+}
+
+// wrap seals dek under the KEK, prefixing the nonce. The KEK ID is bound as
+// additional data so a wrapped key can't be relabelled.
+func (k *Keyring) wrap(id string, dek []byte) ([]byte, error) {
+	kek := k.keks[id]
+	nonce := make([]byte, kek.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return kek.Seal(nonce, nonce, dek, []byte(id)), nil
+}
+
+func (k *Keyring) unwrap(e Envelope) ([]byte, error) {
+	kek, ok := k.keks[e.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, e.KeyID)
+	}
+	if len(e.WrappedKey) < kek.NonceSize() {
+		return nil, errors.New("encryption: wrapped key too short")
+	}
+	n := kek.NonceSize()
+	return kek.Open(nil, e.WrappedKey[:n], e.WrappedKey[n:], []byte(e.KeyID))
+}
+
+var current atomic.Pointer[Keyring]
+
+// Configure sets the keyring String fields use. With none configured they
+// are stored in plaintext, and sealed values can't be read.
+func Configure(k *Keyring) {
+	current.Store(k)
+}
+
+func Current() *Keyring {
+	return current.Load()
+}
@@ -0,0 +1,244 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestNewKeyring(t *testing.T) {
+	good := newKey(t)
+	tests := []struct {
+		name   string
+		keys   map[string][]byte
+		active string
+		ok     bool
+	}{
+		{name: "one key", keys: map[string][]byte{"a": good}, active: "a", ok: true},
+		{name: "active is one of several", keys: map[string][]byte{"a": good, "b": newKey(t)}, active: "b", ok: true},
+		{name: "active not configured", keys: map[string][]byte{"a": good}, active: "b"},
+		{name: "no keys", keys: nil, active: "a"},
+		{name: "short key", keys: map[string][]byte{"a": good[:16]}, active: "a"},
+		{name: "long key", keys: map[string][]byte{"a": append(good, 0)}, active: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := NewKeyring(tt.keys, tt.active)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && k.ActiveKey() != tt.active {
+				t.Errorf("active = %q, want %q", k.ActiveKey(), tt.active)
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	k, err := NewKeyring(map[string][]byte{"a": newKey(t)}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewKeyring(map[string][]byte{"a": newKey(t)}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		sealAD []byte
+		tamper func(e *Envelope)
+		open   *Keyring
+		openAD []byte
+		fail   bool
+		is     error
+	}{
+		{name: "round trip", open: k},
+		{name: "round trip with additional data", sealAD: []byte("id-1"), open: k, openAD: []byte("id-1")},
+		{name: "different additional data", sealAD: []byte("id-1"), open: k, openAD: []byte("id-2"), fail: true},
+		{name: "additional data dropped", sealAD: []byte("id-1"), tamper: func(e *Envelope) { e.Bound = false }, open: k, openAD: []byte("id-1"), fail: true},
+		{name: "unbound value opens with any additional data", open: k, openAD: []byte("id-2")},
+		{name: "same key ID, different KEK", open: other, fail: true},
+		{name: "relabelled key", tamper: func(e *Envelope) { e.KeyID = "b" }, open: k, fail: true, is: ErrUnknownKey},
+		{name: "truncated wrapped key", tamper: func(e *Envelope) { e.WrappedKey = e.WrappedKey[:4] }, open: k, fail: true},
+		{name: "altered ciphertext", tamper: func(e *Envelope) { e.Ciphertext[0] ^= 1 }, open: k, fail: true},
+		{name: "altered wrapped key", tamper: func(e *Envelope) { e.WrappedKey[len(e.WrappedKey)-1] ^= 1 }, open: k, fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext := []byte("taxi from the airport")
+			e, err := k.Seal(plaintext, tt.sealAD)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(e.Ciphertext, plaintext) {
+				t.Fatal("ciphertext contains the plaintext")
+			}
+			if e.Bound != (tt.sealAD != nil) {
+				t.Errorf("bound = %v with additional data %q", e.Bound, tt.sealAD)
+			}
+			if tt.tamper != nil {
+				tt.tamper(&e)
+			}
+			got, err := tt.open.Open(e, tt.openAD)
+			if tt.fail {
+				if err == nil {
+					t.Fatalf("opened to %q, want an error", got)
+				}
+				if tt.is != nil && !errors.Is(err, tt.is) {
+					t.Fatalf("err = %v, want %v", err, tt.is)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Fatalf("open = %q, want %q", got, plaintext)
+			}
+		})
+	}
+}
+
+func TestSealUsesFreshDataKeys(t *testing.T) {
+	k, err := NewKeyring(map[string][]byte{"a": newKey(t)}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e1, err := k.Seal([]byte("same"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2, err := k.Seal([]byte("same"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(e1.WrappedKey, e2.WrappedKey) || bytes.Equal(e1.Ciphertext, e2.Ciphertext) {
+		t.Error("two seals of the same value share a data key or ciphertext")
+	}
+}
+
+func TestRewrapRotatesKEK(t *testing.T) {
+	oldKey, newKEK := newKey(t), newKey(t)
+	before, err := NewKeyring(map[string][]byte{"old": oldKey}, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := NewKeyring(map[string][]byte{"old": oldKey, "new": newKEK}, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retired, err := NewKeyring(map[string][]byte{"new": newKEK}, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := []byte("expense-1")
+	e, err := before.Seal([]byte("hotel"), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := retired.Open(e, id); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("open with the old KEK gone: err = %v, want ErrUnknownKey", err)
+	}
+
+	rewrapped, err := after.Rewrap(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewrapped.KeyID != "new" {
+		t.Errorf("key ID = %q, want new", rewrapped.KeyID)
+	}
+	if !bytes.Equal(rewrapped.Ciphertext, e.Ciphertext) || !bytes.Equal(rewrapped.Nonce, e.Nonce) || !rewrapped.Bound {
+		t.Error("rewrap changed the sealed value, not just its data key")
+	}
+	got, err := retired.Open(rewrapped, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hotel" {
+		t.Errorf("open = %q, want hotel", got)
+	}
+
+	again, err := after.Rewrap(rewrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.WrappedKey, rewrapped.WrappedKey) {
+		t.Error("rewrap of a value already under the active key changed it")
+	}
+	if _, err := retired.Rewrap(e); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("rewrap with the old KEK gone: err = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestBindOpen(t *testing.T) {
+	k, err := NewKeyring(map[string][]byte{"a": newKey(t)}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Configure(k)
+	t.Cleanup(func() { Configure(nil) })
+
+	marshal := func(v any) bson.RawValue {
+		t.Helper()
+		typ, data, err := bson.MarshalValue(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bson.RawValue{Type: typ, Value: data}
+	}
+	id1, id2 := []byte("expense-1"), []byte("expense-2")
+	sealed := marshal(Bind("parking", id1))
+	legacy, err := k.Seal([]byte("parking"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		value bson.RawValue
+		id    []byte
+		want  String
+		ok    bool
+	}{
+		{name: "own document", value: sealed, id: id1, want: "parking", ok: true},
+		{name: "copied to another document", value: sealed, id: id2},
+		{name: "read without a document", value: sealed, id: nil},
+		{name: "sealed before binding", value: marshal(legacy), id: id2, want: "parking", ok: true},
+		{name: "plaintext", value: marshal("parking"), id: id1, want: "parking", ok: true},
+		{name: "empty stays plaintext", value: marshal(Bind("", id1)), id: id2, want: "", ok: true},
+		{name: "missing", value: bson.RawValue{}, id: id1, want: "", ok: true},
+		{name: "null", value: bson.RawValue{Type: bson.TypeNull}, id: id1, want: "", ok: true},
+		{name: "wrong type", value: marshal(int32(1)), id: id1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Open(tt.value, tt.id)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+			if got != tt.want {
+				t.Errorf("Open = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if sealed.Type != bson.TypeEmbeddedDocument {
+		t.Errorf("bound value stored as %s, want a sealed envelope", sealed.Type)
+	}
+	if _, _, err := bson.MarshalValue(Bind("parking", nil)); err == nil {
+		t.Error("sealed a value without a document ID")
+	}
+	if _, _, err := bson.MarshalValue(String("parking")); err == nil {
+		t.Error("wrote an unbound String with a keyring configured")
+	}
+}
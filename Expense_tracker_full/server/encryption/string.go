@@ -0,0 +1,98 @@
+package encryption
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+var errUnbound = errors.New("encryption: a String must be written with Bind and read with Open")
+
+// String is a string field sealed on its way into Mongo and opened on its
+// way out, so handlers work with plaintext. It is bound to the document it
+// belongs to: the owning type writes it with Bind and reads it with Open,
+// passing the document's ID, so a sealed value copied to another document
+// fails to open. Plaintext values written before encryption was enabled are
+// read as they are; empty strings are never sealed. Sealed fields can't be
+// matched by queries.
+type String string
+
+// MarshalBSONValue only writes plaintext. With a keyring configured it fails
+// rather than store a value that isn't bound to its document.
+func (s String) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if Current() != nil && s != "" {
+		return 0, nil, errUnbound
+	}
+	return bsontype.String, bsoncore.AppendString(nil, string(s)), nil
+}
+
+func (s *String) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	v, err := Open(bson.RawValue{Type: t, Value: data}, nil)
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+// Bound is a String tied to the ID of the document it is stored in.
+type Bound struct {
+	s  String
+	id []byte
+}
+
+// Bind prepares s to be stored in the document identified by id.
+func Bind(s String, id []byte) Bound {
+	return Bound{s: s, id: id}
+}
+
+func (b Bound) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	k := Current()
+	if k == nil || b.s == "" {
+		return bsontype.String, bsoncore.AppendString(nil, string(b.s)), nil
+	}
+	if len(b.id) == 0 {
+		return 0, nil, errors.New("encryption: can't seal a value for a document without an ID")
+	}
+	e, err := k.Seal([]byte(b.s), b.id)
+	if err != nil {
+		return 0, nil, err
+	}
+	return bson.MarshalValue(e)
+}
+
+// Open reads a String written by Bind with the same id. A missing value
+// reads as empty. Envelopes sealed before values were bound open with any id.
+func Open(v bson.RawValue, id []byte) (String, error) {
+	switch v.Type {
+	case 0, bsontype.Null:
+		return "", nil
+	case bsontype.String:
+		s, ok := v.StringValueOK()
+		if !ok {
+			return "", errors.New("encryption: malformed string")
+		}
+		return String(s), nil
+	case bsontype.EmbeddedDocument:
+		var e Envelope
+		if err := v.Unmarshal(&e); err != nil {
+			return "", err
+		}
+		if e.Bound && id == nil {
+			return "", errUnbound
+		}
+		k := Current()
+		if k == nil {
+			return "", errors.New("encryption: value is sealed but no keyring is configured")
+		}
+		plaintext, err := k.Open(e, id)
+		if err != nil {
+			return "", err
+		}
+		return String(plaintext), nil
+	}
+	return "", fmt.Errorf("encryption: cannot decode %s into a String", v.Type)
+}
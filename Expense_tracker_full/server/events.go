@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"gin-app/events"
+	"gin-app/middleware"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxWatchBackoff = time.Minute
+
+var (
+	hub = events.NewHub()
+
+	// changeStreamLive is set while the Mongo change stream is feeding the
+	// hub, so handlers stop publishing and events aren't delivered twice.
+	changeStreamLive atomic.Bool
+)
+
+func publishExpense(eventType string, workspaceID, id primitive.ObjectID, data any) {
+	if changeStreamLive.Load() {
+		return
+	}
+	hub.Publish(events.Event{Type: eventType, WorkspaceID: workspaceID, ResourceID: id, Data: data})
+}
+
+// watchExpenses feeds the hub from a change stream on the expenses collection
+// until ctx is cancelled, reconnecting with backoff. While the stream is down
+// handlers fall back to publishing in-process.
+func watchExpenses(ctx context.Context) {
+	var resume bson.Raw
+	backoff := time.Second
+	for {
+		opened, err := followExpenses(ctx, &resume)
+		changeStreamLive.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if opened {
+			backoff = time.Second
+		} else {
+			resume = nil
+		}
+		slog.Warn("expense change stream stopped, publishing in-process", "error", err, "retry_in", backoff.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxWatchBackoff)
+	}
+}
+
+func followExpenses(ctx context.Context, resume *bson.Raw) (bool, error) {
+	opts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetFullDocumentBeforeChange(options.WhenAvailable)
+	if *resume != nil {
+		opts.SetResumeAfter(*resume)
+	}
+	stream, err := collection.Watch(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}}}}},
+	}, opts)
+	if err != nil {
+		return false, err
+	}
+	defer stream.Close(context.Background())
+	changeStreamLive.Store(true)
+	slog.Info("publishing expense events from change stream")
+
+	for stream.Next(ctx) {
+		*resume = stream.ResumeToken()
+		var change struct {
+			OperationType string `bson:"operationType"`
+			DocumentKey   struct {
+				ID primitive.ObjectID `bson:"_id"`
+			} `bson:"documentKey"`
+			FullDocument *Expense `bson:"fullDocument"`
+			Before       *Expense `bson:"fullDocumentBeforeChange"`
+		}
+		if err := stream.Decode(&change); err != nil {
+			slog.Warn("skipping undecodable change event", "error", err)
+			continue
+		}
+		switch change.OperationType {
+		case "insert":
+			if change.FullDocument != nil {
+				hub.Publish(events.Event{Type: events.ExpenseCreated, WorkspaceID: change.FullDocument.WorkspaceID, ResourceID: change.DocumentKey.ID, Data: change.FullDocument})
+			}
+		case "update", "replace":
+			// The lookup returns nothing when the document was deleted
+			// before it ran; the delete event follows.
+			if change.FullDocument != nil {
+				hub.Publish(events.Event{Type: events.ExpenseUpdated, WorkspaceID: change.FullDocument.WorkspaceID, ResourceID: change.DocumentKey.ID, Data: change.FullDocument})
+			}
+		case "delete":
+			if change.Before == nil {
+				slog.Debug("delete event without pre-image, workspace unknown", "id", change.DocumentKey.ID.Hex())
+				continue
+			}
+			hub.Publish(events.Event{Type: events.ExpenseDeleted, WorkspaceID: change.Before.WorkspaceID, ResourceID: change.DocumentKey.ID})
+		}
+	}
+	return true, stream.Err()
+}
+
+// streamEvents serves the selected workspace's events as Server-Sent Events. Event ids
+// increase across the whole hub, so clients can order but not gap-check them.
+func streamEvents(c *gin.Context) {
+	ch, cancel := hub.Subscribe(currentWorkspaceID(c))
+	defer cancel()
+	heartbeat := time.NewTicker(cfg.Events.Heartbeat)
+	defer heartbeat.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Render(-1, sse.Event{Event: "ready", Data: gin.H{"change_streams": changeStreamLive.Load()}})
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case e, ok := <-ch:
+			if !ok {
+				return false
+			}
+			c.Render(-1, sse.Event{Id: strconv.FormatUint(e.Seq, 10), Event: e.Type, Data: e})
+			return true
+		case <-heartbeat.C:
+			if !streamAllowed(c) {
+				return false
+			}
+			_, err := io.WriteString(w, ": heartbeat\n\n")
+			return err == nil
+		}
+	})
+}
+
+// streamAllowed repeats the checks the middleware made when the stream
+// opened, so a revoked key, an ended session, a disabled or deleted account,
+// or removal from the workspace closes the stream by the next heartbeat.
+// The client's reconnect then fails with the reason.
+func streamAllowed(c *gin.Context) bool {
+	ctx := c.Request.Context()
+	userID := currentUserID(c)
+	credentials, filter := SessionCollection, bson.M{
+		"token_hash":  middleware.HashToken(middleware.BearerToken(c)),
+		"user_id":     userID,
+		"pending_2fa": bson.M{"$ne": true},
+		"expires_at":  bson.M{"$gt": time.Now()},
+	}
+	if c.GetString("auth_method") == "api_key" {
+		key := c.GetHeader("X-API-Key")
+		if key == "" {
+			key = middleware.BearerToken(c)
+		}
+		credentials, filter = APIKeyCollection, bson.M{
+			"key_hash":   middleware.HashToken(key),
+			"user_id":    userID,
+			"revoked_at": bson.M{"$exists": false},
+		}
+	}
+	found := func(collection *mongo.Collection, filter bson.M) bool {
+		n, err := collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+		if err != nil {
+			slog.Warn("closing event stream, access check failed", "error", err)
+			return false
+		}
+		return n > 0
+	}
+	if !found(credentials, filter) {
+		return false
+	}
+	if !found(UserDataCollection, bson.M{"_id": userID, "disabled": bson.M{"$ne": true}, "deleted_at": bson.M{"$exists": false}}) {
+		return false
+	}
+	return found(WorkspaceCollection, bson.M{"_id": currentWorkspaceID(c), "members.user_id": userID})
+}
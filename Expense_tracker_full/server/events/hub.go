@@ -0,0 +1,125 @@
+// Package events fans out change notifications to the clients subscribed to
+// a workspace's stream. It is purely in-process; the server feeds it either
+// directly from handlers or from a Mongo change stream.
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ExpenseCreated = "expense.created"
+	ExpenseUpdated = "expense.updated"
+	ExpenseDeleted = "expense.deleted"
+
+	subscriberBuffer = 64
+)
+
+type Event struct {
+	Seq         uint64             `json:"seq"`
+	Type        string             `json:"type"`
+	WorkspaceID primitive.ObjectID `json:"-"`
+	ResourceID  primitive.ObjectID `json:"id"`
+	Data        any                `json:"data,omitempty"`
+	At          time.Time          `json:"at"`
+}
+
+type subscriber struct {
+	ch   chan Event
+	once sync.Once
+}
+
+func (s *subscriber) close() {
+	s.once.Do(func() { close(s.ch) })
+}
+
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[primitive.ObjectID]map[*subscriber]struct{}
+	seq    atomic.Uint64
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[primitive.ObjectID]map[*subscriber]struct{})}
+}
+
+// Subscribe registers a listener for workspaceID's events. The channel is
+// closed when cancel is called, when the hub shuts down, or when the
+// subscriber falls too far behind; clients should reconnect and refetch in
+// that case.
+func (h *Hub) Subscribe(workspaceID primitive.ObjectID) (<-chan Event, func()) {
+	sub := &subscriber{ch: make(chan Event, subscriberBuffer)}
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		sub.close()
+		return sub.ch, func() {}
+	}
+	if h.subs[workspaceID] == nil {
+		h.subs[workspaceID] = make(map[*subscriber]struct{})
+	}
+	h.subs[workspaceID][sub] = struct{}{}
+	h.mu.Unlock()
+	subscribers.Inc()
+
+	return sub.ch, func() { h.remove(workspaceID, sub) }
+}
+
+func (h *Hub) remove(workspaceID primitive.ObjectID, sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[workspaceID][sub]; !ok {
+		return
+	}
+	delete(h.subs[workspaceID], sub)
+	if len(h.subs[workspaceID]) == 0 {
+		delete(h.subs, workspaceID)
+	}
+	sub.close()
+	subscribers.Dec()
+}
+
+// Publish never blocks: a subscriber whose buffer is full is disconnected
+// rather than allowed to stall the writer that published the event.
+func (h *Hub) Publish(e Event) {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	e.Seq = h.seq.Add(1)
+	published.WithLabelValues(e.Type).Inc()
+
+	h.mu.Lock()
+	var slow []*subscriber
+	for sub := range h.subs[e.WorkspaceID] {
+		select {
+		case sub.ch <- e:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	h.mu.Unlock()
+	for _, sub := range slow {
+		dropped.Inc()
+		h.remove(e.WorkspaceID, sub)
+	}
+}
+
+// Close disconnects every subscriber so long-lived streams don't hold up a
+// graceful shutdown.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for workspaceID, subs := range h.subs {
+		for sub := range subs {
+			sub.close()
+			subscribers.Dec()
+		}
+		delete(h.subs, workspaceID)
+	}
+}
@@ -0,0 +1,23 @@
+package events
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	subscribers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "expense_event_subscribers",
+		Help: "Clients currently connected to the event stream.",
+	})
+
+	published = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "expense_events_published_total",
+		Help: "Events published to the hub, by type.",
+	}, []string{"type"})
+
+	dropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "expense_event_subscribers_dropped_total",
+		Help: "Subscribers disconnected because they fell behind.",
+	})
+)
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// sessionToken signs email up and logs in over HTTP.
+func sessionToken(t *testing.T, server, email string) string {
+	t.Helper()
+	const password = "correct-horse"
+	post := func(path string, body any) *http.Response {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.Post(server+path, "application/json", bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d", path, res.StatusCode)
+		}
+		return res
+	}
+	post("/signup", gin.H{"email": email, "password": password, "confirm_password": password}).Body.Close()
+	res := post("/login", gin.H{"email": email, "password": password})
+	defer res.Body.Close()
+	var login struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&login); err != nil {
+		t.Fatal(err)
+	}
+	return login.Token
+}
+
+func TestEventStreamClosesWhenAccessEnds(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(user SignupUser) error
+	}{
+		{"session ended", func(user SignupUser) error {
+			_, err := SessionCollection.DeleteMany(context.Background(), bson.M{"user_id": user.ID})
+			return err
+		}},
+		{"account disabled", func(user SignupUser) error {
+			_, err := UserDataCollection.UpdateOne(context.Background(), bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"disabled": true}})
+			return err
+		}},
+		{"removed from workspace", func(user SignupUser) error {
+			_, err := WorkspaceCollection.UpdateOne(context.Background(),
+				bson.M{"_id": user.DefaultWorkspaceID},
+				bson.M{"$set": bson.M{"members": bson.A{}}})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := testServer(t)
+			cfg.Events.Heartbeat = 20 * time.Millisecond
+			const email = "events@example.com"
+			token := sessionToken(t, app.URL, email)
+
+			req, err := http.NewRequest(http.MethodGet, app.URL+"/events", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("status %d", res.StatusCode)
+			}
+			lines := bufio.NewScanner(res.Body)
+			heartbeats := 0
+			for heartbeats < 2 && lines.Scan() {
+				if strings.HasPrefix(lines.Text(), ": heartbeat") {
+					heartbeats++
+				}
+			}
+			if heartbeats < 2 {
+				t.Fatalf("stream ended before access was revoked: %v", lines.Err())
+			}
+
+			if err := tt.revoke(findUserByEmail(t, email)); err != nil {
+				t.Fatal(err)
+			}
+			closed := make(chan struct{})
+			go func() {
+				for lines.Scan() {
+				}
+				close(closed)
+			}()
+			select {
+			case <-closed:
+			case <-time.After(5 * time.Second):
+				t.Fatal("stream still open after access was revoked")
+			}
+		})
+	}
+}
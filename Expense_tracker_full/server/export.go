@@ -0,0 +1,212 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gin-app/middleware"
+	"gin-app/problem"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ExportedSession is a sign-in as it appears in an export, without the token.
+type ExportedSession struct {
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+// ExportedComment is a comment the user left on an expense someone else
+// created; comments on their own expenses are already in expenses.json.
+type ExportedComment struct {
+	ExpenseID   primitive.ObjectID `json:"expense_id"`
+	WorkspaceID primitive.ObjectID `json:"workspace_id"`
+	ExpenseComment
+}
+
+// exportAccount returns a ZIP archive of everything stored about the caller:
+// one JSON file per kind of record, plus the statement files delivered to
+// them. The archive is built in memory so a failure can still be reported as
+// a problem response.
+func exportAccount(c *gin.Context) {
+	ctx := c.Request.Context()
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	var buf bytes.Buffer
+	if err := writeExport(ctx, zip.NewWriter(&buf), user); err != nil {
+		middleware.Logger(ctx).Error("export account", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to export account data")
+		return
+	}
+	filename := "expense-tracker-export-" + time.Now().Format("20060102") + ".zip"
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+func writeExport(ctx context.Context, zw *zip.Writer, user SignupUser) error {
+	byUser := bson.M{"user_id": user.ID}
+	if err := writeJSON(zw, "profile.json", user); err != nil {
+		return err
+	}
+	var expenses []Expense
+	if err := findAll(ctx, collection, byUser, &expenses); err != nil {
+		return fmt.Errorf("expenses: %w", err)
+	}
+	if err := writeJSON(zw, "expenses.json", expenses); err != nil {
+		return err
+	}
+	comments, err := commentsBy(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("comments: %w", err)
+	}
+	if err := writeJSON(zw, "comments.json", comments); err != nil {
+		return err
+	}
+	var income []Income
+	if err := findAll(ctx, IncomeCollection, byUser, &income); err != nil {
+		return fmt.Errorf("income: %w", err)
+	}
+	if err := writeJSON(zw, "income.json", income); err != nil {
+		return err
+	}
+	var goals []SavingsGoal
+	if err := findAll(ctx, GoalCollection, byUser, &goals); err != nil {
+		return fmt.Errorf("goals: %w", err)
+	}
+	if err := writeJSON(zw, "goals.json", goals); err != nil {
+		return err
+	}
+	var searches []SavedSearch
+	if err := findAll(ctx, SavedSearchCollection, byUser, &searches); err != nil {
+		return fmt.Errorf("saved searches: %w", err)
+	}
+	if err := writeJSON(zw, "saved_searches.json", searches); err != nil {
+		return err
+	}
+	var workspaces []Workspace
+	if err := findAll(ctx, WorkspaceCollection, bson.M{"members.user_id": user.ID}, &workspaces); err != nil {
+		return fmt.Errorf("workspaces: %w", err)
+	}
+	if err := writeJSON(zw, "workspaces.json", workspaces); err != nil {
+		return err
+	}
+	var invitations []Invitation
+	filter := bson.M{"$or": bson.A{bson.M{"invited_by": user.ID}, bson.M{"email": user.Email}}}
+	if err := findAll(ctx, InvitationCollection, filter, &invitations); err != nil {
+		return fmt.Errorf("invitations: %w", err)
+	}
+	if err := writeJSON(zw, "invitations.json", invitations); err != nil {
+		return err
+	}
+	var keys []middleware.APIKey
+	if err := findAll(ctx, APIKeyCollection, byUser, &keys); err != nil {
+		return fmt.Errorf("API keys: %w", err)
+	}
+	if err := writeJSON(zw, "api_keys.json", keys); err != nil {
+		return err
+	}
+	var sessions []ExportedSession
+	if err := findAll(ctx, SessionCollection, byUser, &sessions); err != nil {
+		return fmt.Errorf("sessions: %w", err)
+	}
+	if err := writeJSON(zw, "sessions.json", sessions); err != nil {
+		return err
+	}
+	var runs []StatementRun
+	if err := findAll(ctx, StatementCollection, byUser, &runs); err != nil {
+		return fmt.Errorf("statements: %w", err)
+	}
+	if err := writeJSON(zw, "statements.json", runs); err != nil {
+		return err
+	}
+	if err := addStatementFiles(zw, user.ID); err != nil {
+		return fmt.Errorf("statement files: %w", err)
+	}
+	return zw.Close()
+}
+
+func findAll(ctx context.Context, coll *mongo.Collection, filter bson.M, out any) error {
+	cur, err := coll.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return cur.All(ctx, out)
+}
+
+// writeJSON adds v to the archive as indented JSON, writing [] rather than
+// null for an empty list.
+func writeJSON(zw *zip.Writer, name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if string(data) == "null" {
+		data = []byte("[]")
+	}
+	w, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func commentsBy(ctx context.Context, userID primitive.ObjectID) ([]ExportedComment, error) {
+	var expenses []Expense
+	filter := bson.M{"comments.user_id": userID, "user_id": bson.M{"$ne": userID}}
+	if err := findAll(ctx, collection, filter, &expenses); err != nil {
+		return nil, err
+	}
+	comments := []ExportedComment{}
+	for _, e := range expenses {
+		for _, comment := range e.Comments {
+			if comment.UserID == userID {
+				comments = append(comments, ExportedComment{ExpenseID: e.ID, WorkspaceID: e.WorkspaceID, ExpenseComment: comment})
+			}
+		}
+	}
+	return comments, nil
+}
+
+// addStatementFiles copies the statements delivered to the filesystem into
+// statements/ in the archive. Users without any simply have no such folder.
+func addStatementFiles(zw *zip.Writer, userID primitive.ObjectID) error {
+	dir := filepath.Join(cfg.Statements.Dir, userID.Hex())
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || filepath.Ext(entry.Name()) == ".tmp" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create("statements/" + entry.Name())
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"gin-app/problem"
+	"gin-app/statements"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultForecastMonths = 6
+	minForecastMonths     = 3
+	maxForecastMonths     = 24
+
+	// forecastZ gives an 80% interval under a normal approximation.
+	forecastZ          = 1.2816
+	forecastConfidence = 0.8
+
+	// An expense is recurring when the same title and category show up once
+	// a month in at least recurringMinMonths months, and in at least half of
+	// the lookback, with amounts varying by no more than recurringMaxCV.
+	recurringMinMonths = 3
+	recurringMaxCV     = 0.25
+)
+
+type ForecastRange struct {
+	Projected float64 `json:"projected"`
+	Low       float64 `json:"low"`
+	High      float64 `json:"high"`
+
+	sd float64
+}
+
+type CategoryForecast struct {
+	Category     string        `json:"category"`
+	MonthToDate  float64       `json:"month_to_date"`
+	MovingAvg    float64       `json:"moving_average"`
+	RecurringDue float64       `json:"recurring_due"`
+	EndOfMonth   ForecastRange `json:"end_of_month"`
+	NextMonth    ForecastRange `json:"next_month"`
+	Budget       *float64      `json:"budget,omitempty"`
+	BudgetStatus string        `json:"budget_status,omitempty"`
+	AtRisk       bool          `json:"at_risk"`
+}
+
+type RecurringExpense struct {
+	Title          string  `json:"title"`
+	Category       string  `json:"category"`
+	Amount         float64 `json:"amount"`
+	MonthsSeen     int     `json:"months_seen"`
+	SeenThisMonth  bool    `json:"seen_this_month"`
+	monthlyAmounts map[string]float64
+	repeated       bool
+}
+
+type ForecastTotals struct {
+	MonthToDate  float64       `json:"month_to_date"`
+	RecurringDue float64       `json:"recurring_due"`
+	EndOfMonth   ForecastRange `json:"end_of_month"`
+	NextMonth    ForecastRange `json:"next_month"`
+}
+
+type Forecast struct {
+	Month           string             `json:"month"`
+	AsOf            time.Time          `json:"as_of"`
+	Timezone        string             `json:"timezone"`
+	ElapsedFraction float64            `json:"elapsed_fraction"`
+	LookbackMonths  int                `json:"lookback_months"`
+	Confidence      float64            `json:"confidence"`
+	Categories      []CategoryForecast `json:"categories"`
+	Recurring       []RecurringExpense `json:"recurring"`
+	Totals          ForecastTotals     `json:"totals"`
+}
+
+func newRange(projected, sd, floor float64) ForecastRange {
+	return ForecastRange{
+		Projected: projected,
+		Low:       math.Max(projected-forecastZ*sd, floor),
+		High:      projected + forecastZ*sd,
+		sd:        sd,
+	}
+}
+
+func meanStdDev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)-1))
+}
+
+func recurringKey(e Expense) string {
+	return e.Category + "\x00" + strings.ToLower(strings.TrimSpace(e.Title))
+}
+
+// getForecast projects this month's and next month's spending per category.
+// Recurring expenses are forecast at their usual amount; everything else
+// uses a moving average of the last complete months, scaled by how much of
+// the current month is left, with a spread taken from the same history.
+func getForecast(c *gin.Context) {
+	ctx := c.Request.Context()
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	workspace, ok := findCurrentWorkspace(c)
+	if !ok {
+		return
+	}
+	lookback, err := strconv.Atoi(c.DefaultQuery("months", strconv.Itoa(defaultForecastMonths)))
+	if err != nil || lookback < minForecastMonths || lookback > maxForecastMonths {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Invalid lookback").
+			WithCode(problem.CodeValidationFailed).
+			WithField("months", "must be between 3 and 24"))
+		return
+	}
+	loc := locationFor(user.Timezone)
+	now := time.Now().In(loc)
+	monthStart := startOfMonth(now)
+	historyStart := monthStart.AddDate(0, -lookback, 0)
+
+	opts := options.Find().SetProjection(bson.M{"title": 1, "amount": 1, "category": 1, "date": 1})
+	cur, err := collection.Find(ctx, bson.M{"workspace_id": workspace.ID, "date": bson.M{"$gte": historyStart, "$lt": monthStart.AddDate(0, 1, 0)}}, opts)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to load expense history")
+		return
+	}
+	var history []Expense
+	if err := cur.All(ctx, &history); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to load expense history")
+		return
+	}
+	c.JSON(http.StatusOK, buildForecast(history, workspace.Budgets, now, lookback))
+}
+
+// buildForecast works from the expenses of the last lookback complete months
+// plus the current month to date; now carries the user's timezone.
+func buildForecast(history []Expense, budgets map[string]float64, now time.Time, lookback int) Forecast {
+	loc := now.Location()
+	monthStart := startOfMonth(now)
+	monthEnd := monthStart.AddDate(0, 1, 0)
+	historyStart := monthStart.AddDate(0, -lookback, 0)
+	elapsed := now.Sub(monthStart).Seconds() / monthEnd.Sub(monthStart).Seconds()
+	remaining := 1 - elapsed
+
+	months := make([]string, lookback)
+	for i := range months {
+		months[i] = historyStart.AddDate(0, i, 0).Format(monthLayout)
+	}
+	current := monthStart.Format(monthLayout)
+
+	// Pass 1: find recurring expenses in the complete months.
+	series := map[string]*RecurringExpense{}
+	for _, e := range history {
+		month := e.Date.In(loc).Format(monthLayout)
+		key := recurringKey(e)
+		r := series[key]
+		if r == nil {
+			r = &RecurringExpense{Title: e.Title, Category: e.Category, monthlyAmounts: map[string]float64{}}
+			series[key] = r
+		}
+		if month == current {
+			r.SeenThisMonth = true
+			continue
+		}
+		if _, ok := r.monthlyAmounts[month]; ok {
+			r.repeated = true
+		}
+		r.monthlyAmounts[month] += e.Amount
+	}
+	recurring := map[string]*RecurringExpense{}
+	for key, r := range series {
+		if r.repeated || len(r.monthlyAmounts) < recurringMinMonths || len(r.monthlyAmounts)*2 < lookback {
+			continue
+		}
+		var amounts []float64
+		for _, a := range r.monthlyAmounts {
+			amounts = append(amounts, a)
+		}
+		mean, sd := meanStdDev(amounts)
+		if mean <= 0 || sd/mean > recurringMaxCV {
+			continue
+		}
+		r.Amount = mean
+		r.MonthsSeen = len(r.monthlyAmounts)
+		recurring[key] = r
+	}
+
+	// Pass 2: split each category's spending into recurring and the rest.
+	type categoryData struct {
+		discretionary map[string]float64
+		mtd           float64
+		mtdDisc       float64
+		recurringDue  float64
+		recurringNext float64
+	}
+	categories := map[string]*categoryData{}
+	get := func(category string) *categoryData {
+		if categories[category] == nil {
+			categories[category] = &categoryData{discretionary: map[string]float64{}}
+		}
+		return categories[category]
+	}
+	for _, e := range history {
+		d := get(e.Category)
+		_, isRecurring := recurring[recurringKey(e)]
+		month := e.Date.In(loc).Format(monthLayout)
+		if month == current {
+			d.mtd += e.Amount
+			if !isRecurring {
+				d.mtdDisc += e.Amount
+			}
+			continue
+		}
+		if !isRecurring {
+			d.discretionary[month] += e.Amount
+		}
+	}
+	for _, r := range recurring {
+		d := get(r.Category)
+		d.recurringNext += r.Amount
+		if !r.SeenThisMonth {
+			d.recurringDue += r.Amount
+		}
+	}
+	for category := range budgets {
+		get(category)
+	}
+
+	forecasts := []CategoryForecast{}
+	var total ForecastTotals
+	var eomVar, nextVar float64
+	for category, d := range categories {
+		values := make([]float64, len(months))
+		for i, m := range months {
+			values[i] = d.discretionary[m]
+		}
+		avg, sd := meanStdDev(values)
+		if avg == 0 && d.mtdDisc > 0 {
+			// No history: fall back to the current run rate with a wide
+			// spread rather than assuming nothing more will be spent.
+			avg = d.mtdDisc / math.Max(elapsed, 1.0/31)
+			sd = avg
+		}
+		f := CategoryForecast{
+			Category:     category,
+			MonthToDate:  d.mtd,
+			MovingAvg:    avg,
+			RecurringDue: d.recurringDue,
+			EndOfMonth:   newRange(d.mtd+d.recurringDue+avg*remaining, sd*math.Sqrt(remaining), d.mtd),
+			NextMonth:    newRange(d.recurringNext+avg, sd, 0),
+		}
+		if limit, ok := budgets[category]; ok {
+			f.Budget = &limit
+			f.BudgetStatus = statements.BudgetStatus(f.EndOfMonth.Projected, limit)
+			f.AtRisk = f.EndOfMonth.High > limit
+		}
+		forecasts = append(forecasts, f)
+		total.MonthToDate += f.MonthToDate
+		total.RecurringDue += f.RecurringDue
+		total.EndOfMonth.Projected += f.EndOfMonth.Projected
+		total.NextMonth.Projected += f.NextMonth.Projected
+		eomVar += f.EndOfMonth.sd * f.EndOfMonth.sd
+		nextVar += f.NextMonth.sd * f.NextMonth.sd
+	}
+	sort.Slice(forecasts, func(i, j int) bool {
+		if forecasts[i].EndOfMonth.Projected != forecasts[j].EndOfMonth.Projected {
+			return forecasts[i].EndOfMonth.Projected > forecasts[j].EndOfMonth.Projected
+		}
+		return forecasts[i].Category < forecasts[j].Category
+	})
+
+	recurringList := []RecurringExpense{}
+	for _, r := range recurring {
+		recurringList = append(recurringList, *r)
+	}
+	sort.Slice(recurringList, func(i, j int) bool { return recurringList[i].Amount > recurringList[j].Amount })
+
+	total.EndOfMonth = newRange(total.EndOfMonth.Projected, math.Sqrt(eomVar), total.MonthToDate)
+	total.NextMonth = newRange(total.NextMonth.Projected, math.Sqrt(nextVar), 0)
+
+	return Forecast{
+		Month:           current,
+		AsOf:            now,
+		Timezone:        loc.String(),
+		ElapsedFraction: elapsed,
+		LookbackMonths:  lookback,
+		Confidence:      forecastConfidence,
+		Categories:      forecasts,
+		Recurring:       recurringList,
+		Totals:          total,
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"gin-app/statements"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBuildForecast(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Halfway through March: 15 of its 31 days have passed.
+	now := time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)
+	remaining := 16.0 / 31
+	at := func(title, category string, amount float64, date string) Expense {
+		d, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			// Dates alone are taken as midday UTC.
+			d, err = time.Parse(time.DateOnly, date)
+			d = d.Add(12 * time.Hour)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Expense{Title: title, Category: category, Amount: amount, Date: d}
+	}
+	type category struct {
+		name         string
+		monthToDate  float64
+		recurringDue float64
+		endOfMonth   float64
+		budgetStatus string
+		atRisk       bool
+	}
+	tests := []struct {
+		name      string
+		now       time.Time
+		history   []Expense
+		budgets   map[string]float64
+		want      []category
+		recurring []string
+	}{
+		{
+			name: "monthly bill still due",
+			history: []Expense{
+				at("Rent", "Housing", 1000, "2023-12-01"),
+				at("Rent", "Housing", 1000, "2024-01-01"),
+				at("rent ", "Housing", 1000, "2024-02-01"),
+			},
+			want:      []category{{name: "Housing", recurringDue: 1000, endOfMonth: 1000}},
+			recurring: []string{"Rent"},
+		},
+		{
+			name: "monthly bill already paid",
+			history: []Expense{
+				at("Rent", "Housing", 1000, "2023-12-01"),
+				at("Rent", "Housing", 1000, "2024-01-01"),
+				at("Rent", "Housing", 1000, "2024-02-01"),
+				at("Rent", "Housing", 1000, "2024-03-01"),
+			},
+			want:      []category{{name: "Housing", monthToDate: 1000, endOfMonth: 1000}},
+			recurring: []string{"Rent"},
+		},
+		{
+			name: "varying amounts follow the moving average",
+			history: []Expense{
+				at("Market", "Groceries", 100, "2023-12-05"),
+				at("Market", "Groceries", 300, "2024-01-05"),
+				at("Market", "Groceries", 50, "2024-02-05"),
+				at("Market", "Groceries", 40, "2024-03-05"),
+			},
+			want: []category{{name: "Groceries", monthToDate: 40, endOfMonth: 40 + 150*remaining}},
+		},
+		{
+			name: "more than once a month isn't recurring",
+			history: []Expense{
+				at("Gym", "Health", 50, "2023-12-01"),
+				at("Gym", "Health", 50, "2024-01-01"),
+				at("Gym", "Health", 50, "2024-01-15"),
+				at("Gym", "Health", 50, "2024-02-01"),
+			},
+			want: []category{{name: "Health", endOfMonth: 200.0 / 3 * remaining}},
+		},
+		{
+			name: "too few months isn't recurring",
+			history: []Expense{
+				at("Streaming", "Fun", 10, "2024-01-03"),
+				at("Streaming", "Fun", 10, "2024-02-03"),
+			},
+			want: []category{{name: "Fun", endOfMonth: 20.0 / 3 * remaining}},
+		},
+		{
+			name:    "no history uses this month's run rate",
+			history: []Expense{at("Flight", "Travel", 300, "2024-03-10")},
+			want:    []category{{name: "Travel", monthToDate: 300, endOfMonth: 620}},
+		},
+		{
+			name:    "budget without spending",
+			budgets: map[string]float64{"Dining": 100},
+			want:    []category{{name: "Dining", budgetStatus: statements.BudgetOK}},
+		},
+		{
+			name: "within budget but at risk",
+			history: []Expense{
+				at("Market", "Groceries", 100, "2023-12-05"),
+				at("Market", "Groceries", 300, "2024-01-05"),
+				at("Market", "Groceries", 50, "2024-02-05"),
+				at("Market", "Groceries", 40, "2024-03-05"),
+			},
+			budgets: map[string]float64{"Groceries": 200},
+			want: []category{{
+				name:         "Groceries",
+				monthToDate:  40,
+				endOfMonth:   40 + 150*remaining,
+				budgetStatus: statements.BudgetOK,
+				atRisk:       true,
+			}},
+		},
+		{
+			name: "months follow the user's timezone",
+			now:  time.Date(2024, 3, 16, 0, 0, 0, 0, newYork),
+			history: []Expense{
+				at("Rent", "Housing", 1000, "2023-12-01"),
+				at("Rent", "Housing", 1000, "2024-01-01"),
+				// Still February in New York.
+				at("Rent", "Housing", 1000, "2024-03-01T03:00:00Z"),
+			},
+			want:      []category{{name: "Housing", recurringDue: 1000, endOfMonth: 1000}},
+			recurring: []string{"Rent"},
+		},
+		{
+			name: "categories sort by projected spending",
+			history: []Expense{
+				at("Coffee", "Dining", 5, "2024-03-02"),
+				at("Flight", "Travel", 300, "2024-03-10"),
+			},
+			want: []category{
+				{name: "Travel", monthToDate: 300, endOfMonth: 620},
+				{name: "Dining", monthToDate: 5, endOfMonth: 5 * 31.0 / 15},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			when := now
+			if !tt.now.IsZero() {
+				when = tt.now
+			}
+			f := buildForecast(tt.history, tt.budgets, when, 3)
+			if f.Month != "2024-03" || f.LookbackMonths != 3 || f.Timezone != when.Location().String() {
+				t.Errorf("month %s, lookback %d, timezone %s", f.Month, f.LookbackMonths, f.Timezone)
+			}
+			if len(f.Categories) != len(tt.want) {
+				t.Fatalf("categories = %+v, want %d", f.Categories, len(tt.want))
+			}
+			var total float64
+			for i, want := range tt.want {
+				got := f.Categories[i]
+				if got.Category != want.name {
+					t.Fatalf("category %d = %s, want %s", i, got.Category, want.name)
+				}
+				if !near(got.MonthToDate, want.monthToDate) || !near(got.RecurringDue, want.recurringDue) || !near(got.EndOfMonth.Projected, want.endOfMonth) {
+					t.Errorf("%s: month to date %.2f, due %.2f, end of month %.2f; want %.2f, %.2f, %.2f", got.Category,
+						got.MonthToDate, got.RecurringDue, got.EndOfMonth.Projected,
+						want.monthToDate, want.recurringDue, want.endOfMonth)
+				}
+				if got.EndOfMonth.Low > got.EndOfMonth.Projected || got.EndOfMonth.High < got.EndOfMonth.Projected || got.EndOfMonth.Low < got.MonthToDate {
+					t.Errorf("%s: range %+v doesn't bracket the projection above month to date", got.Category, got.EndOfMonth)
+				}
+				if got.BudgetStatus != want.budgetStatus || got.AtRisk != want.atRisk {
+					t.Errorf("%s: budget %q at risk %v, want %q %v", got.Category, got.BudgetStatus, got.AtRisk, want.budgetStatus, want.atRisk)
+				}
+				total += got.EndOfMonth.Projected
+			}
+			if !near(f.Totals.EndOfMonth.Projected, total) {
+				t.Errorf("total end of month = %.2f, want %.2f", f.Totals.EndOfMonth.Projected, total)
+			}
+			var titles []string
+			for _, r := range f.Recurring {
+				titles = append(titles, r.Title)
+			}
+			if len(titles) != len(tt.recurring) || len(titles) > 0 && titles[0] != tt.recurring[0] {
+				t.Errorf("recurring = %v, want %v", titles, tt.recurring)
+			}
+		})
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestMeanStdDev(t *testing.T) {
+	tests := []struct {
+		values   []float64
+		mean, sd float64
+	}{
+		{nil, 0, 0},
+		{[]float64{42}, 42, 0},
+		{[]float64{5, 5, 5}, 5, 0},
+		{[]float64{2, 4, 4, 4, 5, 5, 7, 9}, 5, math.Sqrt(32.0 / 7)},
+	}
+	for _, tt := range tests {
+		mean, sd := meanStdDev(tt.values)
+		if !near(mean, tt.mean) || !near(sd, tt.sd) {
+			t.Errorf("meanStdDev(%v) = (%v, %v), want (%v, %v)", tt.values, mean, sd, tt.mean, tt.sd)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"gin-app/problem"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultLookbackMonths = 6
+	maxLookbackMonths     = 36
+	daysPerMonth          = 365.25 / 12
+)
+
+// SavingsGoal tracks progress towards a target. Expenses in the linked
+// categories (for example a "Savings" transfer category) count as
+// contributions; a goal with no linked categories is funded by net cash flow.
+type SavingsGoal struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID        primitive.ObjectID `json:"-" bson:"user_id"`
+	Name          string             `json:"name" bson:"name"`
+	TargetAmount  float64            `json:"target_amount" bson:"target_amount"`
+	InitialAmount float64            `json:"initial_amount" bson:"initial_amount"`
+	Deadline      *time.Time         `json:"deadline,omitempty" bson:"deadline,omitempty"`
+	Categories    []string           `json:"categories" bson:"categories"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+type GoalProjection struct {
+	GoalID              primitive.ObjectID `json:"goal_id"`
+	Saved               float64            `json:"saved"`
+	Remaining           float64            `json:"remaining"`
+	PercentComplete     float64            `json:"percent_complete"`
+	MonthlySavingsRate  float64            `json:"monthly_savings_rate"`
+	LookbackMonths      int                `json:"lookback_months"`
+	ProjectedCompletion *time.Time         `json:"projected_completion"`
+	RequiredMonthlyRate *float64           `json:"required_monthly_rate,omitempty"`
+	OnTrack             *bool              `json:"on_track,omitempty"`
+	Achieved            bool               `json:"achieved"`
+}
+
+var GoalCollection *mongo.Collection
+
+func validGoal(c *gin.Context, goal SavingsGoal) bool {
+	p := problem.New(http.StatusBadRequest, "Invalid savings goal").WithCode(problem.CodeValidationFailed)
+	if goal.Name == "" {
+		p = p.WithField("name", "is required")
+	}
+	if goal.TargetAmount <= 0 {
+		p = p.WithField("target_amount", "must be greater than 0")
+	}
+	if goal.InitialAmount < 0 {
+		p = p.WithField("initial_amount", "must not be negative")
+	}
+	if len(p.Errors) > 0 {
+		problem.Render(c, p)
+		return false
+	}
+	return true
+}
+
+func findGoal(c *gin.Context) (SavingsGoal, bool) {
+	var goal SavingsGoal
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return goal, false
+	}
+	err = GoalCollection.FindOne(c.Request.Context(), bson.M{"_id": objID, "user_id": currentUserID(c)}).Decode(&goal)
+	if err == mongo.ErrNoDocuments {
+		problem.Abort(c, http.StatusNotFound, "Savings goal not found")
+		return goal, false
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch savings goal")
+		return goal, false
+	}
+	return goal, true
+}
+
+func createGoal(c *gin.Context) {
+	ctx := c.Request.Context()
+	var goal SavingsGoal
+	if err := c.ShouldBindJSON(&goal); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !validGoal(c, goal) {
+		return
+	}
+	now := time.Now()
+	goal.ID = primitive.NilObjectID
+	goal.UserID = currentUserID(c)
+	goal.CreatedAt = now
+	goal.UpdatedAt = now
+	if goal.Categories == nil {
+		goal.Categories = []string{}
+	}
+	res, err := GoalCollection.InsertOne(ctx, goal)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to create savings goal")
+		return
+	}
+	goal.ID = res.InsertedID.(primitive.ObjectID)
+	c.JSON(http.StatusCreated, goal)
+}
+
+func getGoals(c *gin.Context) {
+	ctx := c.Request.Context()
+	cur, err := GoalCollection.Find(ctx, bson.M{"user_id": currentUserID(c)}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch savings goals")
+		return
+	}
+	goals := []SavingsGoal{}
+	if err := cur.All(ctx, &goals); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch savings goals")
+		return
+	}
+	c.JSON(http.StatusOK, goals)
+}
+
+func getGoalByID(c *gin.Context) {
+	goal, ok := findGoal(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, goal)
+}
+
+func updateGoal(c *gin.Context) {
+	ctx := c.Request.Context()
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	var goal SavingsGoal
+	if err := c.ShouldBindJSON(&goal); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !validGoal(c, goal) {
+		return
+	}
+	if goal.Categories == nil {
+		goal.Categories = []string{}
+	}
+	update := bson.M{"$set": bson.M{
+		"name":           goal.Name,
+		"target_amount":  goal.TargetAmount,
+		"initial_amount": goal.InitialAmount,
+		"categories":     goal.Categories,
+		"updated_at":     time.Now(),
+	}}
+	if goal.Deadline != nil {
+		update["$set"].(bson.M)["deadline"] = goal.Deadline
+	} else {
+		update["$unset"] = bson.M{"deadline": ""}
+	}
+	res, err := GoalCollection.UpdateOne(ctx, bson.M{"_id": objID, "user_id": currentUserID(c)}, update)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update savings goal")
+		return
+	}
+	if res.MatchedCount == 0 {
+		problem.Abort(c, http.StatusNotFound, "Savings goal not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Savings goal updated"})
+}
+
+func deleteGoal(c *gin.Context) {
+	ctx := c.Request.Context()
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	res, err := GoalCollection.DeleteOne(ctx, bson.M{"_id": objID, "user_id": currentUserID(c)})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to delete savings goal")
+		return
+	}
+	if res.DeletedCount == 0 {
+		problem.Abort(c, http.StatusNotFound, "Savings goal not found")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// goalContributions sums what went towards the goal between start and end:
+// spending in the linked categories, or net cash flow when none are linked.
+// Goals are personal, so only expenses in the owner's personal workspace
+// count; spending in shared workspaces is not theirs alone.
+func goalContributions(ctx context.Context, goal SavingsGoal, workspaceID primitive.ObjectID, start, end time.Time) (float64, error) {
+	dates := bson.M{"$gte": start, "$lt": end}
+	expenses := bson.M{"workspace_id": workspaceID, "date": dates}
+	if len(goal.Categories) > 0 {
+		expenses["category"] = bson.M{"$in": goal.Categories}
+		return sumAmount(ctx, collection, expenses)
+	}
+	income, err := sumAmount(ctx, IncomeCollection, bson.M{"user_id": goal.UserID, "date": dates})
+	if err != nil {
+		return 0, err
+	}
+	spent, err := sumAmount(ctx, collection, expenses)
+	if err != nil {
+		return 0, err
+	}
+	return income - spent, nil
+}
+
+func sumAmount(ctx context.Context, coll *mongo.Collection, filter bson.M) (float64, error) {
+	cur, err := coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$amount"}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var rows []struct {
+		Total float64 `bson:"total"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return rows[0].Total, nil
+}
+
+// getGoalProjection extrapolates the average monthly contribution over the
+// last complete months to estimate when the goal will be reached.
+func getGoalProjection(c *gin.Context) {
+	ctx := c.Request.Context()
+	goal, ok := findGoal(c)
+	if !ok {
+		return
+	}
+	lookback, err := strconv.Atoi(c.DefaultQuery("lookback_months", strconv.Itoa(defaultLookbackMonths)))
+	if err != nil || lookback < 1 || lookback > maxLookbackMonths {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Invalid lookback").
+			WithCode(problem.CodeValidationFailed).
+			WithField("lookback_months", "must be between 1 and "+strconv.Itoa(maxLookbackMonths)))
+		return
+	}
+	now := time.Now().In(userLocation(ctx, goal.UserID))
+
+	contributed, err := goalContributions(ctx, goal, personalWorkspaceID(c), goal.CreatedAt, now)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to compute goal progress")
+		return
+	}
+	windowEnd := startOfMonth(now)
+	historical, err := goalContributions(ctx, goal, personalWorkspaceID(c), windowEnd.AddDate(0, -lookback, 0), windowEnd)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to compute savings rate")
+		return
+	}
+
+	saved := goal.InitialAmount + contributed
+	projection := GoalProjection{
+		GoalID:             goal.ID,
+		Saved:              saved,
+		Remaining:          math.Max(goal.TargetAmount-saved, 0),
+		PercentComplete:    math.Min(math.Max(saved, 0)/goal.TargetAmount*100, 100),
+		MonthlySavingsRate: historical / float64(lookback),
+		LookbackMonths:     lookback,
+		Achieved:           saved >= goal.TargetAmount,
+	}
+	switch {
+	case projection.Achieved:
+		projection.ProjectedCompletion = &now
+	case projection.MonthlySavingsRate > 0:
+		months := projection.Remaining / projection.MonthlySavingsRate
+		eta := now.Add(time.Duration(months * daysPerMonth * float64(24*time.Hour)))
+		projection.ProjectedCompletion = &eta
+	}
+	if goal.Deadline != nil && !projection.Achieved {
+		monthsLeft := goal.Deadline.Sub(now).Hours() / 24 / daysPerMonth
+		required := projection.Remaining
+		if monthsLeft > 1 {
+			required = projection.Remaining / monthsLeft
+		}
+		onTrack := projection.ProjectedCompletion != nil && !projection.ProjectedCompletion.After(*goal.Deadline)
+		projection.RequiredMonthlyRate = &required
+		projection.OnTrack = &onTrack
+	}
+	c.JSON(http.StatusOK, projection)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"gin-app/middleware"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const pingTimeout = 2 * time.Second
+
+// shuttingDown flips once SIGTERM arrives so load balancers stop routing new
+// traffic here while in-flight requests drain.
+var shuttingDown atomic.Bool
+
+// healthz and readyz both ping Mongo. readyz also fails while the server
+// drains, so a load balancer stops sending traffic before healthz would get
+// the process restarted.
+func healthz(c *gin.Context) {
+	if !pingMongo(c) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "mongo": "ok"})
+}
+
+func readyz(c *gin.Context) {
+	if shuttingDown.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down"})
+		return
+	}
+	if !pingMongo(c) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "mongo": "ok"})
+}
+
+// pingMongo answers 503 when Mongo is unreachable. The driver's error names
+// hosts and topology, so it is logged rather than returned to callers, who
+// are unauthenticated.
+func pingMongo(c *gin.Context) bool {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), pingTimeout)
+	defer cancel()
+	if err := client.Ping(ctx, nil); err != nil {
+		middleware.Logger(ctx).Error("mongo ping failed", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "mongo": "unavailable"})
+		return false
+	}
+	return true
+}
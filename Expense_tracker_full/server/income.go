@@ -0,0 +1,154 @@
+package main
+
+import (
+	"gin-app/problem"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Income struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `json:"-" bson:"user_id"`
+	Source      string             `json:"source" bson:"source"`
+	Amount      float64            `json:"amount" bson:"amount"`
+	Category    string             `json:"category" bson:"category"`
+	Date        time.Time          `json:"date" bson:"date"`
+	Description string             `json:"description" bson:"description"`
+}
+
+var IncomeCollection *mongo.Collection
+
+func validIncome(c *gin.Context, in Income) bool {
+	if in.Source == "" || in.Amount <= 0 {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Source and amount are required").
+			WithCode(problem.CodeValidationFailed).
+			WithField("source", "is required").
+			WithField("amount", "must be greater than 0"))
+		return false
+	}
+	return true
+}
+
+func createIncome(c *gin.Context) {
+	ctx := c.Request.Context()
+	var in Income
+	if err := c.ShouldBindJSON(&in); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !validIncome(c, in) {
+		return
+	}
+	in.ID = primitive.NilObjectID
+	in.UserID = currentUserID(c)
+	if in.Date.IsZero() {
+		in.Date = time.Now()
+	}
+	res, err := IncomeCollection.InsertOne(ctx, in)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to insert income")
+		return
+	}
+	in.ID = res.InsertedID.(primitive.ObjectID)
+	c.JSON(http.StatusCreated, in)
+}
+
+func getIncome(c *gin.Context) {
+	ctx := c.Request.Context()
+	filter := bson.M{"user_id": currentUserID(c)}
+	if category := c.Query("category"); category != "" {
+		filter["category"] = category
+	}
+	cur, err := IncomeCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"date": -1}))
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch income")
+		return
+	}
+	list := []Income{}
+	if err := cur.All(ctx, &list); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch income")
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func getIncomeByID(c *gin.Context) {
+	ctx := c.Request.Context()
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	var in Income
+	err = IncomeCollection.FindOne(ctx, bson.M{"_id": objID, "user_id": currentUserID(c)}).Decode(&in)
+	if err == mongo.ErrNoDocuments {
+		problem.Abort(c, http.StatusNotFound, "Income not found")
+		return
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch income")
+		return
+	}
+	c.JSON(http.StatusOK, in)
+}
+
+func updateIncome(c *gin.Context) {
+	ctx := c.Request.Context()
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	var in Income
+	if err := c.ShouldBindJSON(&in); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !validIncome(c, in) {
+		return
+	}
+	set := bson.M{
+		"source":      in.Source,
+		"amount":      in.Amount,
+		"category":    in.Category,
+		"description": in.Description,
+	}
+	if !in.Date.IsZero() {
+		set["date"] = in.Date
+	}
+	res, err := IncomeCollection.UpdateOne(ctx, bson.M{"_id": objID, "user_id": currentUserID(c)}, bson.M{"$set": set})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update income")
+		return
+	}
+	if res.MatchedCount == 0 {
+		problem.Abort(c, http.StatusNotFound, "Income not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Income updated"})
+}
+
+func deleteIncome(c *gin.Context) {
+	ctx := c.Request.Context()
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	res, err := IncomeCollection.DeleteOne(ctx, bson.M{"_id": objID, "user_id": currentUserID(c)})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to delete income")
+		return
+	}
+	if res.DeletedCount == 0 {
+		problem.Abort(c, http.StatusNotFound, "Income not found")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
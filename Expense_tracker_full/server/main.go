@@ -2,27 +2,54 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"gin-app/api"
+	"gin-app/config"
+	"gin-app/encryption"
+	"gin-app/events"
+	"gin-app/metrics"
 	"gin-app/middleware"
+	"gin-app/migrations"
+	"gin-app/problem"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type SignupUser struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Email     string             `bson:"email" json:"email"`
-	Password  string             `bson:"password" json:"password"`
-	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Email              string             `bson:"email" json:"email"`
+	Password           string             `bson:"password" json:"-"`
+	DisplayName        string             `bson:"display_name" json:"display_name"`
+	BaseCurrency       string             `bson:"base_currency" json:"base_currency"`
+	Timezone           string             `bson:"timezone" json:"timezone"`
+	Identities         []ExternalIdentity `bson:"identities,omitempty" json:"identities,omitempty"`
+	Role               string             `bson:"role" json:"role"`
+	Disabled           bool               `bson:"disabled" json:"disabled"`
+	DefaultWorkspaceID primitive.ObjectID `bson:"default_workspace_id" json:"default_workspace_id"`
+	DeletedAt          *time.Time         `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	HasPassword        bool               `bson:"-" json:"has_password"`
+
+	TOTPEnabled       bool     `bson:"totp_enabled" json:"totp_enabled"`
+	TOTPSecret        string   `bson:"totp_secret,omitempty" json:"-"`
+	TOTPPendingSecret string   `bson:"totp_pending_secret,omitempty" json:"-"`
+	TOTPLastStep      int64    `bson:"totp_last_step,omitempty" json:"-"`
+	RecoveryCodes     []string `bson:"recovery_codes,omitempty" json:"-"`
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
 }
 
 type LoginUser struct {
@@ -34,224 +61,680 @@ type LoginUser struct {
 }
 
 type Expense struct {
-	ID          string    `json:"id,omitempty"`
-	Title       string    `json:"title" bson:"title"`
-	Amount      float64   `json:"amount" bson:"amount"`
-	Category    string    `json:"category" bson:"category"`
-	Date        time.Time `json:"date" bson:"date"`
-	Description string    `json:"description" bson:"description"`
+	ID              primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	WorkspaceID     primitive.ObjectID  `json:"workspace_id" bson:"workspace_id"`
+	UserID          primitive.ObjectID  `json:"created_by" bson:"user_id"`
+	Title           string              `json:"title" bson:"title"`
+	Amount          float64             `json:"amount" bson:"amount"`
+	Category        string              `json:"category" bson:"category"`
+	Date            time.Time           `json:"date" bson:"date"`
+	Description     encryption.String   `json:"description" bson:"description"`
+	Tags            []string            `json:"tags" bson:"tags"`
+	Type            string              `json:"type" bson:"type"`
+	MerchantID      *primitive.ObjectID `json:"merchant_id,omitempty" bson:"merchant_id,omitempty"`
+	Mileage         *MileageDetails     `json:"mileage,omitempty" bson:"mileage,omitempty"`
+	PerDiem         *PerDiemDetails     `json:"per_diem,omitempty" bson:"per_diem,omitempty"`
+	Status          string              `json:"status" bson:"status"`
+	ApproverID      *primitive.ObjectID `json:"approver_id,omitempty" bson:"approver_id,omitempty"`
+	StatusChangedAt *time.Time          `json:"status_changed_at,omitempty" bson:"status_changed_at,omitempty"`
+	Comments        []ExpenseComment    `json:"comments,omitempty" bson:"comments,omitempty"`
+	Revision        int64               `json:"revision" bson:"revision"`
+	SyncSeq         int64               `json:"-" bson:"sync_seq"`
+	SchemaVersion   int                 `json:"-" bson:"schema_version"`
+}
+
+// MarshalBSON seals the description to the expense's ID, so a sealed
+// description copied onto another expense won't open.
+func (e Expense) MarshalBSON() ([]byte, error) {
+	type stored Expense
+	doc := stored(e)
+	doc.Description = ""
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var id []byte
+	if !e.ID.IsZero() {
+		id = e.ID[:]
+	}
+	t, data, err := bson.MarshalValue(encryption.Bind(e.Description, id))
+	if err != nil {
+		return nil, fmt.Errorf("expense %s description: %w", e.ID.Hex(), err)
+	}
+	return replaceElement(raw, "description", &bsoncore.Value{Type: t, Data: data})
+}
+
+func (e *Expense) UnmarshalBSON(data []byte) error {
+	type stored Expense
+	description := bson.Raw(data).Lookup("description")
+	rest, err := replaceElement(data, "description", nil)
+	if err != nil {
+		return err
+	}
+	if err := bson.Unmarshal(rest, (*stored)(e)); err != nil {
+		return err
+	}
+	e.Description, err = encryption.Open(description, e.ID[:])
+	if err != nil {
+		return fmt.Errorf("expense %s description: %w", e.ID.Hex(), err)
+	}
+	return nil
+}
+
+// replaceElement returns doc with key's value replaced by value, or with key
+// removed when value is nil.
+func replaceElement(doc []byte, key string, value *bsoncore.Value) ([]byte, error) {
+	elems, err := bsoncore.Document(doc).Elements()
+	if err != nil {
+		return nil, err
+	}
+	idx, out := bsoncore.AppendDocumentStart(nil)
+	for _, el := range elems {
+		if el.Key() != key {
+			out = append(out, el...)
+			continue
+		}
+		if value != nil {
+			out = bsoncore.AppendValueElement(out, key, *value)
+		}
+	}
+	return bsoncore.AppendDocumentEnd(out, idx)
 }
 
 var (
 	client             *mongo.Client
 	collection         *mongo.Collection
 	UserDataCollection *mongo.Collection
-	ctx                = context.Background()
+	SessionCollection  *mongo.Collection
+	cfg                config.Config
 )
 
 func main() {
-	_ = godotenv.Load()
-	mongoURI := os.Getenv("MONGO_URI")
-	if mongoURI == "" {
-		log.Fatal("MONGO_URI not set in .env")
-	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
 	var err error
-	client, err = mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	cfg, err = config.Load()
 	if err != nil {
 		log.Fatal(err)
 	}
-	collection = client.Database("Expense_Tracker").Collection("expense_data")
-	UserDataCollection = client.Database("Expense_Tracker").Collection("user_data")
-	r := gin.Default()
-	r.Use(cors.Default())
+	if cfg.Encryption.Enabled() {
+		keys, err := cfg.Encryption.DecodedKeys()
+		if err != nil {
+			log.Fatal(err)
+		}
+		keyring, err := encryption.NewKeyring(keys, cfg.Encryption.ActiveKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		encryption.Configure(keyring)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	mongoOpts := options.Client().
+		ApplyURI(cfg.Mongo.URI).
+		SetConnectTimeout(cfg.Mongo.ConnectTimeout).
+		SetMonitor(metrics.MongoMonitor(func(ctx context.Context, command, failure string) {
+			middleware.Logger(ctx).Error("mongo command failed", "command", command, "error", failure)
+		}))
+	if cfg.Mongo.MaxPoolSize > 0 {
+		mongoOpts.SetMaxPoolSize(cfg.Mongo.MaxPoolSize)
+	}
+	client, err = mongo.Connect(ctx, mongoOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	err = client.Ping(pingCtx, nil)
+	cancel()
+	if err != nil {
+		log.Fatalf("mongo ping failed: %v", err)
+	}
+	db := client.Database(cfg.Mongo.Database)
+	useDatabase(db, cfg.Mongo.Collections)
+	if err := migrations.Run(ctx, db, cfg.Mongo.Collections); err != nil {
+		log.Fatal(err)
+	}
+	oidcConfig = cfg.Auth.OIDC
+	if cfg.Events.ChangeStreams {
+		go watchExpenses(ctx)
+	}
+	if cfg.Statements.Enabled {
+		go runStatementScheduler(ctx, newDeliverer(cfg.Statements))
+	}
+	go runRetentionJob(ctx)
+	if keyring := encryption.Current(); keyring != nil {
+		go runRotationJob(ctx, keyring)
+	}
+	spec, err := api.Load(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r := newRouter(spec)
+
+	srv := &http.Server{Addr: cfg.Server.ListenAddr, Handler: r}
+	srv.RegisterOnShutdown(hub.Close)
+	go func() {
+		var err error
+		if cfg.Server.TLSEnabled() {
+			err = srv.ListenAndServeTLS(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	shuttingDown.Store(true)
+	slog.Info("shutting down, draining in-flight requests")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("http shutdown", "error", err)
+	}
+	if err := client.Disconnect(shutdownCtx); err != nil {
+		slog.Error("mongo disconnect", "error", err)
+	}
+}
+
+// newRouter wires every route, with the spec validating requests.
+func newRouter(spec *api.Spec) *gin.Engine {
+	r := gin.New()
+	r.Use(middleware.RequestLogger(), gin.CustomRecovery(func(c *gin.Context, _ any) {
+		problem.Abort(c, http.StatusInternalServerError, "Internal Server Error")
+	}), metrics.Middleware(), corsMiddleware(cfg.CORS))
+	r.NoRoute(func(c *gin.Context) {
+		problem.Abort(c, http.StatusNotFound, "No route for "+c.Request.Method+" "+c.Request.URL.Path)
+	})
 
 	r.GET("/", func(c *gin.Context) {
 		c.String(http.StatusOK, "Expense tracker is running")
 	})
-	r.POST("/expense", createExpense)
-	r.GET("/expense", getExpense)
-	r.GET("/expense/:id", getExpenseByID)
-	r.PUT("/expense/:id", updateExpense)
-	r.DELETE("/expense/:id", deleteExpense)
-	r.GET("/categories", getCategories)
-	r.POST("/login", Login)
-	r.POST("/signup", middleware.AuthMiddleware(UserDataCollection), SignupHandler)
-	r.Run(":5000")
+	r.GET("/healthz", healthz)
+	r.GET("/readyz", readyz)
+	r.GET("/metrics", metrics.Handler())
+	r.GET("/openapi.json", spec.Handler())
+
+	public := r.Group("/", middleware.RequestTimeout(cfg.Server.RequestTimeout), spec.Validator())
+	public.POST("/login", Login)
+	public.POST("/login/2fa", LoginTwoFactor)
+	public.POST("/signup", middleware.AuthMiddleware(UserDataCollection), SignupHandler)
+	public.GET("/auth/oidc/login", OIDCLogin)
+	public.GET("/auth/oidc/callback", OIDCCallback)
+
+	read := middleware.RequireScope(middleware.ScopeRead)
+	write := middleware.RequireScope(middleware.ScopeWrite)
+	export := middleware.RequireScope(middleware.ScopeExport)
+
+	editor := middleware.RequireRole(middleware.RoleAdmin, middleware.RoleMember)
+	contributor := middleware.RequireWorkspaceRole(middleware.WorkspaceOwner, middleware.WorkspaceEditor)
+
+	auth := public.Group("/",
+		middleware.SessionMiddleware(SessionCollection, APIKeyCollection),
+		middleware.AccountMiddleware(UserDataCollection),
+	)
+	auth.POST("/searches", editor, write, createSavedSearch)
+	auth.GET("/searches", read, getSavedSearches)
+	auth.GET("/searches/:id", read, getSavedSearchByID)
+	auth.PUT("/searches/:id", editor, write, updateSavedSearch)
+	auth.DELETE("/searches/:id", editor, write, deleteSavedSearch)
+	auth.POST("/income", editor, write, createIncome)
+	auth.GET("/income", read, getIncome)
+	auth.GET("/income/:id", read, getIncomeByID)
+	auth.PUT("/income/:id", editor, write, updateIncome)
+	auth.DELETE("/income/:id", editor, write, deleteIncome)
+	auth.POST("/goals", editor, write, createGoal)
+	auth.GET("/goals", read, getGoals)
+	auth.GET("/goals/:id", read, getGoalByID)
+	auth.PUT("/goals/:id", editor, write, updateGoal)
+	auth.DELETE("/goals/:id", editor, write, deleteGoal)
+	auth.GET("/goals/:id/projection", read, getGoalProjection)
+	auth.GET("/statements", read, listStatementRuns)
+	auth.POST("/workspaces", editor, write, createWorkspace)
+	auth.GET("/workspaces", read, listWorkspaces)
+	auth.GET("/workspaces/:id", read, getWorkspace)
+	auth.PUT("/workspaces/:id", editor, write, updateWorkspace)
+	auth.DELETE("/workspaces/:id", editor, write, deleteWorkspace)
+	auth.PUT("/workspaces/:id/members/:user_id", editor, write, updateMember)
+	auth.DELETE("/workspaces/:id/members/:user_id", write, removeMember)
+	auth.POST("/workspaces/:id/invitations", editor, write, createInvitation)
+	auth.GET("/workspaces/:id/invitations", read, listInvitations)
+	auth.DELETE("/workspaces/:id/invitations/:invitation_id", editor, write, revokeInvitation)
+	auth.POST("/invitations/accept", write, respondToInvitation(true))
+	auth.POST("/invitations/decline", write, respondToInvitation(false))
+
+	// Everything a workspace owns is reached through the workspace selected
+	// by the X-Workspace-ID header.
+	ws := auth.Group("/", middleware.WorkspaceMiddleware(WorkspaceCollection))
+	ws.POST("/expense", editor, contributor, write, createExpense)
+	ws.GET("/expense", read, getExpense)
+	ws.GET("/expense/export", export, exportExpenses)
+	ws.GET("/expense/anomalies", read, getAnomalies)
+	ws.POST("/expense/anomalies/:id/dismiss", editor, contributor, write, dismissAnomaly)
+	ws.GET("/expense/:id", read, getExpenseByID)
+	ws.PUT("/expense/:id", editor, contributor, write, updateExpense)
+	ws.DELETE("/expense/:id", editor, contributor, write, deleteExpense)
+	ws.POST("/expense/:id/status", editor, contributor, write, setExpenseStatus)
+	ws.PUT("/expense/:id/approver", editor, contributor, write, setExpenseApprover)
+	ws.POST("/expense/:id/comments", editor, contributor, write, addExpenseComment)
+	ws.GET("/approvals", read, getApprovalQueue)
+	ws.GET("/sync", read, pullChanges)
+	ws.POST("/sync", editor, contributor, write, pushChanges)
+	ws.GET("/categories", read, getCategories)
+	ws.GET("/tags", read, getTags)
+	ws.GET("/searches/:id/expenses", read, runSavedSearch)
+	ws.GET("/reports/cashflow", read, getCashFlow)
+	ws.GET("/reports/forecast", read, getForecast)
+	ws.GET("/reports/statement", read, getStatement)
+	ws.GET("/reports/chart", read, getChart)
+	ws.GET("/reports/merchants", read, getMerchantReport)
+	ws.GET("/budgets", read, getBudgets)
+	ws.PUT("/budgets", editor, contributor, write, setBudgets)
+	ws.GET("/rates", read, getRates)
+	ws.PUT("/rates", editor, contributor, write, setRates)
+	ws.POST("/merchants", editor, contributor, write, createMerchant)
+	ws.GET("/merchants", read, getMerchants)
+	ws.GET("/merchants/:id", read, getMerchantByID)
+	ws.PUT("/merchants/:id", editor, contributor, write, updateMerchant)
+	ws.DELETE("/merchants/:id", editor, contributor, write, deleteMerchant)
+	ws.POST("/merchants/:id/merge", editor, contributor, write, mergeMerchants)
+
+	me := auth.Group("/me", middleware.RequireSession())
+	me.GET("", getProfile)
+	me.PUT("", updateProfile)
+	me.PUT("/password", changePassword)
+	me.DELETE("", deleteAccount)
+	me.GET("/export", exportAccount)
+	me.POST("/api-keys", createAPIKey)
+	me.GET("/api-keys", listAPIKeys)
+	me.DELETE("/api-keys/:id", revokeAPIKey)
+	me.POST("/2fa/setup", setupTwoFactor)
+	me.POST("/2fa/enable", enableTwoFactor)
+	me.POST("/2fa/disable", disableTwoFactor)
+
+	// The event stream is long-lived, so it sits outside the request timeout.
+	r.GET("/events", spec.Validator(),
+		middleware.SessionMiddleware(SessionCollection, APIKeyCollection),
+		middleware.AccountMiddleware(UserDataCollection),
+		middleware.WorkspaceMiddleware(WorkspaceCollection),
+		read, streamEvents)
+
+	admin := auth.Group("/admin", middleware.RequireSession(), middleware.RequireRole(middleware.RoleAdmin))
+	admin.GET("/users", listUsers)
+	admin.PUT("/users/:id/role", setUserRole)
+	admin.POST("/users/:id/disable", setUserDisabled(true))
+	admin.POST("/users/:id/enable", setUserDisabled(false))
+	admin.GET("/stats", getSystemStats)
+	return r
+}
+
+// useDatabase points the collection variables at db.
+func useDatabase(db *mongo.Database, cols config.Collections) {
+	collection = db.Collection(cols.Expenses)
+	UserDataCollection = db.Collection(cols.Users)
+	SessionCollection = db.Collection(cols.Sessions)
+	APIKeyCollection = db.Collection(cols.APIKeys)
+	OIDCStateCollection = db.Collection(cols.OIDCState)
+	IncomeCollection = db.Collection(cols.Income)
+	GoalCollection = db.Collection(cols.Goals)
+	SavedSearchCollection = db.Collection(cols.SavedSearches)
+	StatementCollection = db.Collection(cols.Statements)
+	AnomalyCollection = db.Collection(cols.Anomalies)
+	CategoryStatsCollection = db.Collection(cols.CategoryStats)
+	WorkspaceCollection = db.Collection(cols.Workspaces)
+	InvitationCollection = db.Collection(cols.Invitations)
+	TombstoneCollection = db.Collection(cols.Tombstones)
+	MerchantCollection = db.Collection(cols.Merchants)
 }
 
 func Login(c *gin.Context) {
+	ctx := c.Request.Context()
 	var user LoginUser
-	if err := c.BindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := c.ShouldBindJSON(&user); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	var stored SignupUser
+	err := UserDataCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		problem.Render(c, problem.New(http.StatusUnauthorized, "Invalid email or password").WithCode(problem.CodeInvalidCredentials))
+		return
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch user")
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte(user.Password)) != nil {
+		problem.Render(c, problem.New(http.StatusUnauthorized, "Invalid email or password").WithCode(problem.CodeInvalidCredentials))
+		return
+	}
+	if stored.Disabled {
+		problem.Render(c, problem.New(http.StatusForbidden, "Account is disabled").WithCode("account_disabled"))
+		return
+	}
+	if stored.TOTPEnabled {
+		challenge, err := createTwoFactorChallenge(ctx, stored.ID, nil)
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Failed to create two-factor challenge")
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"two_factor_required": true, "two_factor_token": challenge})
+		return
+	}
+	token, expiresAt, err := createSession(ctx, stored.ID, nil)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to create session")
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "expires_at": expiresAt})
+}
+
+// createSession starts a login session. reauthenticatedAt is set when the
+// login went through the identity provider.
+func createSession(ctx context.Context, userID primitive.ObjectID, reauthenticatedAt *time.Time) (string, time.Time, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(cfg.Auth.SessionTTL)
+	_, err = SessionCollection.InsertOne(ctx, middleware.Session{
+		UserID:            userID,
+		TokenHash:         middleware.HashToken(token),
+		ReauthenticatedAt: reauthenticatedAt,
+		CreatedAt:         now,
+		ExpiresAt:         expiresAt,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
 
+func currentUserID(c *gin.Context) primitive.ObjectID {
+	id, _ := c.Get("user_id")
+	oid, _ := id.(primitive.ObjectID)
+	return oid
 }
 
 func SignupHandler(c *gin.Context) {
+	ctx := c.Request.Context()
 	email, _ := c.Get("email")
 	password, _ := c.Get("password")
 	if email == nil || password == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email and password are required"})
+		problem.Abort(c, http.StatusBadRequest, "Email and password are required")
 		return
 	}
-	_, err := UserDataCollection.InsertOne(ctx, bson.M{
-		"email":      email,
-		"password":   password,
-		"created_at": time.Now(),
-		"updated_at": time.Now(),
+	res, err := UserDataCollection.InsertOne(ctx, bson.M{
+		"email":         email,
+		"password":      password,
+		"role":          roleForEmail(email.(string)),
+		"disabled":      false,
+		"display_name":  "",
+		"base_currency": defaultCurrency,
+		"timezone":      defaultTimezone,
+		"created_at":    time.Now(),
+		"updated_at":    time.Now(),
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		problem.Abort(c, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+	if _, err := createPersonalWorkspace(ctx, res.InsertedID.(primitive.ObjectID)); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to create workspace")
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Signup successful"})
 }
 
 func createExpense(c *gin.Context) {
+	ctx := c.Request.Context()
 	var newExpense Expense
-	if err := c.BindJSON(&newExpense); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := c.ShouldBindJSON(&newExpense); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !applyExpenseType(c, &newExpense, nil) {
 		return
 	}
 	if newExpense.Title == "" || newExpense.Amount <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Title and amount are required"})
+		problem.Render(c, problem.New(http.StatusBadRequest, "Title and amount are required").
+			WithCode(problem.CodeValidationFailed).
+			WithField("title", "is required").
+			WithField("amount", "must be greater than 0"))
+		return
+	}
+	newExpense.Tags = normaliseTags(newExpense.Tags)
+	if !validTags(c, newExpense.Tags) {
+		return
+	}
+	if !assignMerchant(c, &newExpense) {
 		return
 	}
+	// The ID is set here, not by the driver, because the description is
+	// sealed to it.
+	newExpense.ID = primitive.NewObjectID()
+	newExpense.WorkspaceID = currentWorkspaceID(c)
+	newExpense.UserID = currentUserID(c)
 	newExpense.Date = time.Now()
-
-	doc := bson.M{
-		"title":       newExpense.Title,
-		"amount":      newExpense.Amount,
-		"category":    newExpense.Category,
-		"date":        newExpense.Date,
-		"description": newExpense.Description,
+	newExpense.Status = StatusDraft
+	newExpense.ApproverID = nil
+	newExpense.StatusChangedAt = nil
+	newExpense.Comments = nil
+	newExpense.SchemaVersion = migrations.ExpenseSchemaVersion
+	newExpense.Revision = 1
+	seq, done, err := nextSyncSeq(ctx, newExpense.WorkspaceID)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to insert expense")
+		return
 	}
+	defer done()
+	newExpense.SyncSeq = seq
 
-	res, err := collection.InsertOne(ctx, doc)
+	res, err := collection.InsertOne(ctx, newExpense)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert expense"})
+		problem.Abort(c, http.StatusInternalServerError, "Failed to insert expense")
 		return
 	}
 
 	oid, ok := res.InsertedID.(primitive.ObjectID)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get inserted ID"})
+		problem.Abort(c, http.StatusInternalServerError, "Failed to get inserted ID")
 		return
 	}
-	newExpense.ID = oid.Hex()
+	newExpense.ID = oid
+	trackExpense(ctx, newExpense, nil)
+	publishExpense(events.ExpenseCreated, newExpense.WorkspaceID, oid, newExpense)
 	c.JSON(http.StatusCreated, newExpense)
 }
 
 func getExpense(c *gin.Context) {
-	category := c.Query("category")
-	filter := bson.M{}
-	if category != "" {
-		filter["category"] = category
+	f, ok := expenseFilterFromQuery(c)
+	if !ok {
+		return
 	}
-	cur, err := collection.Find(ctx, filter)
+	listExpenses(c, f)
+}
+
+func listExpenses(c *gin.Context, f ExpenseFilter) {
+	ctx := c.Request.Context()
+	cur, err := collection.Find(ctx, f.Match(currentWorkspaceID(c)), options.Find().SetSort(bson.M{"date": -1}))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch expenses"})
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch expenses")
 		return
 	}
 	defer cur.Close(ctx)
-	var list []Expense
+	list := []Expense{}
 	for cur.Next(ctx) {
 		var e Expense
-		var raw bson.M
-		if err := cur.Decode(&raw); err == nil {
-			e.ID = raw["_id"].(primitive.ObjectID).Hex()
-			e.Title = raw["title"].(string)
-			e.Amount = raw["amount"].(float64)
-			e.Category = raw["category"].(string)
-			e.Description = raw["description"].(string)
-			e.Date = raw["date"].(primitive.DateTime).Time()
-			list = append(list, e)
+		if err := cur.Decode(&e); err != nil {
+			middleware.Logger(ctx).Warn("skipping malformed expense", "id", cur.Current.Lookup("_id").String(), "error", err)
+			continue
 		}
+		list = append(list, e)
+	}
+	if err := cur.Err(); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch expenses")
+		return
 	}
 	c.JSON(http.StatusOK, list)
 }
 
 func getExpenseByID(c *gin.Context) {
+	ctx := c.Request.Context()
 	id := c.Param("id")
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
 		return
 	}
 	var e Expense
-	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&e)
+	err = collection.FindOne(ctx, bson.M{"_id": objID, "workspace_id": currentWorkspaceID(c)}).Decode(&e)
 	if err == mongo.ErrNoDocuments {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+		problem.Abort(c, http.StatusNotFound, "Expense not found")
 		return
 	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch expense"})
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch expense")
 		return
 	}
-	e.ID = objID.Hex()
 	c.JSON(http.StatusOK, e)
 }
 
 func updateExpense(c *gin.Context) {
-	id := c.Param("id")
-	objID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+	ctx := c.Request.Context()
+	current, ok := findExpense(c)
+	if !ok {
 		return
 	}
 	var updated Expense
 	if err := c.ShouldBindJSON(&updated); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !applyExpenseType(c, &updated, &current) {
 		return
 	}
 	if updated.Title == "" || updated.Amount <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Title and amount are required"})
+		problem.Render(c, problem.New(http.StatusBadRequest, "Title and amount are required").
+			WithCode(problem.CodeValidationFailed).
+			WithField("title", "is required").
+			WithField("amount", "must be greater than 0"))
 		return
 	}
-	update := bson.M{
-		"$set": bson.M{
-			"title":       updated.Title,
-			"amount":      updated.Amount,
-			"category":    updated.Category,
-			"description": updated.Description,
-		},
+	updated.Tags = normaliseTags(updated.Tags)
+	if !validTags(c, updated.Tags) {
+		return
 	}
-	res, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, update)
+	if !assignMerchant(c, &updated) {
+		return
+	}
+	update := expenseUpdate(current.ID, updated)
+	done, err := stampChange(ctx, currentWorkspaceID(c), update)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update expense"})
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update expense")
+		return
+	}
+	defer done()
+	// The revision pins the expense to the one its rate was kept from.
+	var previous Expense
+	filter := bson.M{"_id": current.ID, "workspace_id": currentWorkspaceID(c), "revision": current.Revision, "status": bson.M{"$in": editableStatuses}}
+	err = collection.FindOneAndUpdate(ctx, filter, update).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		abortLocked(c, current.ID)
 		return
 	}
-	if res.MatchedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update expense")
 		return
 	}
+	saved := withEdits(previous, updated)
+	trackExpense(ctx, saved, &previous)
+	publishExpense(events.ExpenseUpdated, saved.WorkspaceID, saved.ID, saved)
 	c.JSON(http.StatusOK, gin.H{"message": "Expense updated"})
 }
 
+// expenseUpdate sets the fields a client may edit on expense id; workflow
+// and sync fields are left to their own handlers.
+func expenseUpdate(id primitive.ObjectID, updated Expense) bson.M {
+	set := bson.M{
+		"title":       updated.Title,
+		"amount":      updated.Amount,
+		"category":    updated.Category,
+		"description": encryption.Bind(updated.Description, id[:]),
+		"tags":        updated.Tags,
+		"type":        updated.Type,
+	}
+	unset := bson.M{}
+	if updated.MerchantID != nil {
+		set["merchant_id"] = updated.MerchantID
+	} else {
+		unset["merchant_id"] = ""
+	}
+	if updated.Mileage != nil {
+		set["mileage"] = updated.Mileage
+	} else {
+		unset["mileage"] = ""
+	}
+	if updated.PerDiem != nil {
+		set["per_diem"] = updated.PerDiem
+	} else {
+		unset["per_diem"] = ""
+	}
+	return bson.M{"$set": set, "$unset": unset}
+}
+
+// withEdits returns previous as it reads after expenseUpdate and
+// stampChange have been applied to it.
+func withEdits(previous, updated Expense) Expense {
+	saved := previous
+	saved.Title = updated.Title
+	saved.Amount = updated.Amount
+	saved.Category = updated.Category
+	saved.Description = updated.Description
+	saved.Tags = updated.Tags
+	saved.Type = updated.Type
+	saved.MerchantID = updated.MerchantID
+	saved.Mileage = updated.Mileage
+	saved.PerDiem = updated.PerDiem
+	saved.Revision++
+	return saved
+}
+
 func deleteExpense(c *gin.Context) {
+	ctx := c.Request.Context()
 	id := c.Param("id")
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
 		return
 	}
-	res, err := collection.DeleteOne(ctx, bson.M{"_id": objID})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete expense"})
+	var deleted Expense
+	filter := bson.M{"_id": objID, "workspace_id": currentWorkspaceID(c), "status": bson.M{"$in": editableStatuses}}
+	err = collection.FindOneAndDelete(ctx, filter).Decode(&deleted)
+	if err == mongo.ErrNoDocuments {
+		abortLocked(c, objID)
 		return
 	}
-	if res.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to delete expense")
 		return
 	}
+	untrackExpense(ctx, deleted)
+	recordTombstone(ctx, deleted)
+	publishExpense(events.ExpenseDeleted, deleted.WorkspaceID, objID, nil)
 	c.Status(http.StatusNoContent)
 }
 
 func getCategories(c *gin.Context) {
-	catCur, err := collection.Distinct(ctx, "category", bson.M{})
+	ctx := c.Request.Context()
+	catCur, err := collection.Distinct(ctx, "category", bson.M{"workspace_id": currentWorkspaceID(c)})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch categories")
 		return
 	}
 	var cats []string
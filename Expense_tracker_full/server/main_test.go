@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"gin-app/api"
+	"gin-app/config"
+	"gin-app/encryption"
+	"gin-app/migrations"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// testDatabase points the collections at a fresh, migrated database on the
+// server in MONGO_TEST_URI, and drops it when the test ends. Tests that need
+// Mongo skip without it.
+func testDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = config.Default()
+	db := client.Database("expense_test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	useDatabase(db, cfg.Mongo.Collections)
+	if err := migrations.Run(ctx, db, cfg.Mongo.Collections); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// testServer serves the full router over a fresh test database.
+func testServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	testDatabase(t)
+	spec, err := api.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newRouter(spec))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// A sealed description only opens on the expense it was written for.
+func TestExpenseDescriptionBoundToID(t *testing.T) {
+	key := make([]byte, encryption.KeySize)
+	keyring, err := encryption.NewKeyring(map[string][]byte{"a": key}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryption.Configure(keyring)
+	t.Cleanup(func() { encryption.Configure(nil) })
+
+	original := Expense{ID: primitive.NewObjectID(), Title: "Taxi", Amount: 30, Description: "airport to hotel", Tags: []string{}}
+	raw, err := bson.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("airport")) {
+		t.Fatal("description stored in plaintext")
+	}
+	var decoded Expense
+	if err := bson.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID != original.ID || decoded.Title != original.Title || decoded.Description != original.Description {
+		t.Errorf("round trip = %+v, want %+v", decoded, original)
+	}
+
+	other, err := bson.Marshal(Expense{ID: primitive.NewObjectID(), Title: "Lunch", Amount: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := replaceElement(other, "description", &bsoncore.Value{Type: bson.TypeEmbeddedDocument, Data: bson.Raw(raw).Lookup("description").Value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bson.Unmarshal(copied, &decoded); err == nil {
+		t.Errorf("description copied to another expense opened as %q", decoded.Description)
+	}
+
+	if _, err := bson.Marshal(Expense{Title: "No ID", Description: "secret"}); err == nil {
+		t.Error("sealed a description for an expense without an ID")
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"gin-app/events"
+	"gin-app/merchants"
+	"gin-app/middleware"
+	"gin-app/problem"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxMerchantName    = 100
+	maxMerchantAliases = 50
+	maxMergeSources    = 50
+)
+
+var MerchantCollection *mongo.Collection
+
+type MerchantInput struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+type MergeRequest struct {
+	MerchantIDs []string `json:"merchant_ids"`
+}
+
+type MerchantStat struct {
+	MerchantID primitive.ObjectID `json:"merchant_id" bson:"_id"`
+	Name       string             `json:"name" bson:"name"`
+	Count      int                `json:"count" bson:"count"`
+	Total      float64            `json:"total" bson:"total"`
+	Average    float64            `json:"average" bson:"average"`
+	LastDate   time.Time          `json:"last_date" bson:"last_date"`
+}
+
+// assignMerchant sets e's merchant: an explicit merchant_id must belong to the
+// workspace, otherwise one is resolved from the title.
+func assignMerchant(c *gin.Context, e *Expense) bool {
+	p, err := matchMerchant(c.Request.Context(), currentWorkspaceID(c), e)
+	if err != nil {
+		middleware.Logger(c.Request.Context()).Error("match merchant", "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to match merchant")
+		return false
+	}
+	if p != nil {
+		problem.Render(c, p)
+		return false
+	}
+	return true
+}
+
+func matchMerchant(ctx context.Context, workspaceID primitive.ObjectID, e *Expense) (*problem.Problem, error) {
+	if e.MerchantID != nil {
+		n, err := MerchantCollection.CountDocuments(ctx, bson.M{"_id": *e.MerchantID, "workspace_id": workspaceID})
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return problem.New(http.StatusBadRequest, "Unknown merchant").
+				WithCode(problem.CodeValidationFailed).
+				WithField("merchant_id", "must be a merchant in this workspace"), nil
+		}
+		return nil, nil
+	}
+	id, err := merchants.Resolve(ctx, MerchantCollection, workspaceID, e.Title)
+	if err != nil {
+		return nil, err
+	}
+	if !id.IsZero() {
+		e.MerchantID = &id
+	}
+	return nil, nil
+}
+
+func findMerchant(c *gin.Context, id string) (merchants.Merchant, bool) {
+	var m merchants.Merchant
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return m, false
+	}
+	err = MerchantCollection.FindOne(c.Request.Context(), bson.M{"_id": objID, "workspace_id": currentWorkspaceID(c)}).Decode(&m)
+	if err == mongo.ErrNoDocuments {
+		problem.Abort(c, http.StatusNotFound, "Merchant not found")
+		return m, false
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch merchant")
+		return m, false
+	}
+	return m, true
+}
+
+// bindMerchant reads a merchant's name and aliases. Aliases are stored as
+// keys, and the name's own key is always one of them.
+func bindMerchant(c *gin.Context) (MerchantInput, bool) {
+	var req MerchantInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return req, false
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	p := problem.New(http.StatusBadRequest, "Invalid merchant").WithCode(problem.CodeValidationFailed)
+	if req.Name == "" || len(req.Name) > maxMerchantName {
+		p.WithField("name", "must be between 1 and 100 characters")
+	} else if merchants.Key(req.Name) == "" {
+		p.WithField("name", "must contain a word without digits")
+	}
+	aliases := []string{merchants.Key(req.Name)}
+	for _, alias := range req.Aliases {
+		if key := merchants.Key(alias); key != "" {
+			aliases = append(aliases, key)
+		}
+	}
+	slices.Sort(aliases)
+	req.Aliases = slices.Compact(aliases)
+	if len(req.Aliases) > maxMerchantAliases {
+		p.WithField("aliases", "at most 50 aliases are allowed")
+	}
+	if len(p.Errors) > 0 {
+		problem.Render(c, p)
+		return req, false
+	}
+	return req, true
+}
+
+func abortAliasTaken(c *gin.Context) {
+	problem.Render(c, problem.New(http.StatusConflict, "An alias already belongs to another merchant").
+		WithCode("merchant_alias_taken"))
+}
+
+func createMerchant(c *gin.Context) {
+	ctx := c.Request.Context()
+	req, ok := bindMerchant(c)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	m := merchants.Merchant{
+		WorkspaceID: currentWorkspaceID(c),
+		Name:        req.Name,
+		Aliases:     req.Aliases,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	res, err := MerchantCollection.InsertOne(ctx, m)
+	if mongo.IsDuplicateKeyError(err) {
+		abortAliasTaken(c)
+		return
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to create merchant")
+		return
+	}
+	m.ID = res.InsertedID.(primitive.ObjectID)
+	c.JSON(http.StatusCreated, m)
+}
+
+func getMerchants(c *gin.Context) {
+	ctx := c.Request.Context()
+	cur, err := MerchantCollection.Find(ctx, bson.M{"workspace_id": currentWorkspaceID(c)}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch merchants")
+		return
+	}
+	list := []merchants.Merchant{}
+	if err := cur.All(ctx, &list); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch merchants")
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func getMerchantByID(c *gin.Context) {
+	m, ok := findMerchant(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+// updateMerchant renames a merchant and replaces its aliases. Expenses keep
+// their merchant; the aliases only steer future matches.
+func updateMerchant(c *gin.Context) {
+	ctx := c.Request.Context()
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	req, ok := bindMerchant(c)
+	if !ok {
+		return
+	}
+	res, err := MerchantCollection.UpdateOne(ctx, bson.M{"_id": objID, "workspace_id": currentWorkspaceID(c)}, bson.M{"$set": bson.M{
+		"name":       req.Name,
+		"aliases":    req.Aliases,
+		"updated_at": time.Now(),
+	}})
+	if mongo.IsDuplicateKeyError(err) {
+		abortAliasTaken(c)
+		return
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update merchant")
+		return
+	}
+	if res.MatchedCount == 0 {
+		problem.Abort(c, http.StatusNotFound, "Merchant not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Merchant updated"})
+}
+
+// deleteMerchant only removes merchants no expense uses; duplicates should be
+// merged instead, so their expenses and aliases carry over.
+func deleteMerchant(c *gin.Context) {
+	ctx := c.Request.Context()
+	m, ok := findMerchant(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	n, err := collection.CountDocuments(ctx, bson.M{"workspace_id": m.WorkspaceID, "merchant_id": m.ID})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to delete merchant")
+		return
+	}
+	if n > 0 {
+		problem.Render(c, problem.New(http.StatusConflict, "Merchant has expenses; merge it into another merchant instead").
+			WithCode("merchant_in_use"))
+		return
+	}
+	if _, err := MerchantCollection.DeleteOne(ctx, bson.M{"_id": m.ID}); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to delete merchant")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// mergeMerchants folds duplicate merchants into the one named in the path:
+// their expenses move over, then they are deleted and their aliases added to
+// it. Moving the expenses first means a failed merge doesn't leave an expense
+// pointing at a deleted merchant; expenses that resolved to a source while
+// it was being merged are moved again once the sources are gone.
+func mergeMerchants(c *gin.Context) {
+	ctx := c.Request.Context()
+	target, ok := findMerchant(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	var req MergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.MerchantIDs) == 0 || len(req.MerchantIDs) > maxMergeSources {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Invalid merge").
+			WithCode(problem.CodeValidationFailed).
+			WithField("merchant_ids", "must list between 1 and 50 merchants"))
+		return
+	}
+	ids := make([]primitive.ObjectID, 0, len(req.MerchantIDs))
+	for _, v := range req.MerchantIDs {
+		id, err := primitive.ObjectIDFromHex(v)
+		if err != nil || id == target.ID {
+			problem.Render(c, problem.New(http.StatusBadRequest, "Invalid merge").
+				WithCode(problem.CodeValidationFailed).
+				WithField("merchant_ids", "must be IDs of other merchants"))
+			return
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	var sources []merchants.Merchant
+	cur, err := MerchantCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "workspace_id": target.WorkspaceID})
+	if err == nil {
+		err = cur.All(ctx, &sources)
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to merge merchants")
+		return
+	}
+	if len(sources) != len(ids) {
+		problem.Abort(c, http.StatusNotFound, "Merchant not found")
+		return
+	}
+
+	moved, err := moveExpenses(ctx, target, ids)
+	if err != nil {
+		middleware.Logger(ctx).Error("merge merchants", "merchant_id", target.ID.Hex(), "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to merge merchants")
+		return
+	}
+	// The sources must go before their aliases can move, as an alias belongs
+	// to one merchant at a time.
+	if _, err := MerchantCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}, "workspace_id": target.WorkspaceID}); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to merge merchants")
+		return
+	}
+	late, err := moveExpenses(ctx, target, ids)
+	if err != nil {
+		middleware.Logger(ctx).Error("merge merchants", "merchant_id", target.ID.Hex(), "error", err)
+		problem.Abort(c, http.StatusInternalServerError, "Failed to merge merchants")
+		return
+	}
+	moved += late
+	for _, m := range sources {
+		target.Aliases = append(target.Aliases, m.Aliases...)
+	}
+	slices.Sort(target.Aliases)
+	target.Aliases = slices.Compact(target.Aliases)
+	target.UpdatedAt = time.Now()
+	_, err = MerchantCollection.UpdateOne(ctx, bson.M{"_id": target.ID}, bson.M{"$set": bson.M{
+		"aliases":    target.Aliases,
+		"updated_at": target.UpdatedAt,
+	}})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to merge merchants")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"merchant": target, "moved_expenses": moved})
+}
+
+// moveExpenses points the expenses of the from merchants at target one by one,
+// so each gets its own place in the change feed.
+func moveExpenses(ctx context.Context, target merchants.Merchant, from []primitive.ObjectID) (int, error) {
+	filter := bson.M{"workspace_id": target.WorkspaceID, "merchant_id": bson.M{"$in": from}}
+	ids, err := collection.Distinct(ctx, "_id", filter)
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for _, id := range ids {
+		update := bson.M{"$set": bson.M{"merchant_id": target.ID}}
+		done, err := stampChange(ctx, target.WorkspaceID, update)
+		if err != nil {
+			return moved, err
+		}
+		var saved Expense
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = collection.FindOneAndUpdate(ctx, bson.M{"_id": id, "merchant_id": bson.M{"$in": from}}, update, opts).Decode(&saved)
+		done()
+		if err == mongo.ErrNoDocuments {
+			// Edited or deleted since the IDs were read.
+			continue
+		}
+		if err != nil {
+			return moved, err
+		}
+		publishExpense(events.ExpenseUpdated, saved.WorkspaceID, saved.ID, saved)
+		moved++
+	}
+	return moved, nil
+}
+
+// getMerchantReport totals spending per merchant over the same month range
+// and expense filters as the cash flow report, largest first. Expenses
+// without a merchant are left out.
+func getMerchantReport(c *gin.Context) {
+	ctx := c.Request.Context()
+	loc := userLocation(ctx, currentUserID(c))
+	start, end, ok := monthRange(c, loc)
+	if !ok {
+		return
+	}
+	f, ok := expenseFilterFromQuery(c)
+	if !ok {
+		return
+	}
+	workspaceID := currentWorkspaceID(c)
+	match := bson.M{"$and": bson.A{
+		f.Match(workspaceID),
+		bson.M{"date": bson.M{"$gte": start, "$lt": end}, "merchant_id": bson.M{"$exists": true}},
+	}}
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       "$merchant_id",
+			"count":     bson.M{"$sum": 1},
+			"total":     bson.M{"$sum": "$amount"},
+			"average":   bson.M{"$avg": "$amount"},
+			"last_date": bson.M{"$max": "$date"},
+		}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         MerchantCollection.Name(),
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "merchant",
+		}}},
+		{{Key: "$set", Value: bson.M{"name": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$merchant.name", 0}}, ""}}}}},
+		{{Key: "$unset", Value: "merchant"}},
+		{{Key: "$sort", Value: bson.D{{Key: "total", Value: -1}, {Key: "name", Value: 1}}}},
+	})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to compute merchant report")
+		return
+	}
+	stats := []MerchantStat{}
+	if err := cur.All(ctx, &stats); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to compute merchant report")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":      start.Format(monthLayout),
+		"to":        end.AddDate(0, -1, 0).Format(monthLayout),
+		"merchants": stats,
+	})
+}
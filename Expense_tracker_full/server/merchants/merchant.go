@@ -0,0 +1,104 @@
+// Package merchants groups expense titles that name the same business, such
+// as "STARBUCKS #1234" and "Starbucks", under one merchant per workspace.
+package merchants
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxCandidates caps the merchants Resolve scores a title against.
+const maxCandidates = 200
+
+// Merchant is a business expenses are spent at. Aliases are the title keys
+// (see Key) that resolve to it; each key belongs to at most one merchant in a
+// workspace.
+type Merchant struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	WorkspaceID primitive.ObjectID `json:"workspace_id" bson:"workspace_id"`
+	Name        string             `json:"name" bson:"name"`
+	Aliases     []string           `json:"aliases" bson:"aliases"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// Resolve returns the merchant for an expense titled title, matching the
+// title's key exactly against aliases first and then fuzzily, and creating a
+// merchant when nothing is close. A fuzzy match learns the key as a new
+// alias. It returns the nil ID for titles without a usable key.
+func Resolve(ctx context.Context, coll *mongo.Collection, workspaceID primitive.ObjectID, title string) (primitive.ObjectID, error) {
+	key := Key(title)
+	if key == "" {
+		return primitive.NilObjectID, nil
+	}
+	id, err := findByAlias(ctx, coll, workspaceID, key)
+	if err != mongo.ErrNoDocuments {
+		return id, err
+	}
+
+	// Only merchants with an alias starting like key are scored, which keeps
+	// the scan on the alias index; a misspelt first letter or two gets a
+	// merchant of its own, to be merged by hand.
+	filter := bson.M{"workspace_id": workspaceID, "aliases": bson.M{"$regex": "^" + regexp.QuoteMeta(keyPrefix(key))}}
+	opts := options.Find().SetProjection(bson.M{"aliases": 1}).SetLimit(maxCandidates)
+	cur, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return id, err
+	}
+	var candidates []Merchant
+	if err := cur.All(ctx, &candidates); err != nil {
+		return id, err
+	}
+	best := Similarity
+	for _, m := range candidates {
+		for _, alias := range m.Aliases {
+			if score := Score(key, alias); score >= best {
+				id, best = m.ID, score
+			}
+		}
+	}
+	if !id.IsZero() {
+		_, err := coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$addToSet": bson.M{"aliases": key}})
+		if mongo.IsDuplicateKeyError(err) {
+			// Another request created a merchant for the key meanwhile.
+			return findByAlias(ctx, coll, workspaceID, key)
+		}
+		return id, err
+	}
+
+	now := time.Now()
+	res, err := coll.InsertOne(ctx, Merchant{
+		WorkspaceID: workspaceID,
+		Name:        DisplayName(title),
+		Aliases:     []string{key},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return findByAlias(ctx, coll, workspaceID, key)
+	}
+	if err != nil {
+		return id, err
+	}
+	return res.InsertedID.(primitive.ObjectID), nil
+}
+
+func findByAlias(ctx context.Context, coll *mongo.Collection, workspaceID primitive.ObjectID, key string) (primitive.ObjectID, error) {
+	var m Merchant
+	opts := options.FindOne().SetProjection(bson.M{"_id": 1})
+	err := coll.FindOne(ctx, bson.M{"workspace_id": workspaceID, "aliases": key}, opts).Decode(&m)
+	return m.ID, err
+}
+
+// keyPrefix is the start of key that fuzzy matches must share: its first two
+// characters.
+func keyPrefix(key string) string {
+	r := []rune(key)
+	return string(r[:min(len(r), 2)])
+}
@@ -0,0 +1,118 @@
+package merchants
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Similarity is the lowest edit-distance ratio at which two keys are taken to
+// name the same merchant.
+const Similarity = 0.85
+
+// processorPrefixes are added by card processors in front of the merchant's
+// own name, as in "SQ *BLUE BOTTLE".
+var processorPrefixes = []string{"sq *", "sq*", "tst *", "tst*", "pp *", "pp*", "paypal *", "sp *", "sp*"}
+
+var corporateSuffixes = map[string]bool{
+	"inc": true, "llc": true, "ltd": true, "co": true, "corp": true,
+	"plc": true, "gmbh": true, "com": true,
+}
+
+// words splits title into the words that name the merchant, in their
+// original case: processor prefixes, anything after a '#', words containing
+// digits (store numbers, dates, card suffixes) and trailing corporate
+// suffixes are dropped.
+func words(title string) []string {
+	s := strings.TrimSpace(title)
+	lower := strings.ToLower(s)
+	for _, p := range processorPrefixes {
+		if strings.HasPrefix(lower, p) {
+			s = s[len(p):]
+			break
+		}
+	}
+	if i := strings.IndexByte(s, '#'); i >= 0 {
+		s = s[:i]
+	}
+	var out []string
+	for _, w := range strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '&'
+	}) {
+		w = strings.Trim(w, "'")
+		if w == "" || strings.IndexFunc(w, unicode.IsDigit) >= 0 {
+			continue
+		}
+		out = append(out, w)
+	}
+	for len(out) > 1 && corporateSuffixes[strings.ToLower(out[len(out)-1])] {
+		out = out[:len(out)-1]
+	}
+	return out
+}
+
+// Key normalises an expense title for matching, so "STARBUCKS #1234" and
+// "Starbucks" share the key "starbucks". It is empty when nothing in the
+// title looks like a name.
+func Key(title string) string {
+	ws := words(title)
+	for i, w := range ws {
+		ws[i] = strings.ToLower(strings.ReplaceAll(w, "'", ""))
+	}
+	return strings.Join(ws, " ")
+}
+
+// DisplayName is the name given to a merchant created from title. Titles in
+// capitals, as card statements print them, are converted to title case.
+func DisplayName(title string) string {
+	ws := words(title)
+	name := strings.Join(ws, " ")
+	if name != strings.ToUpper(name) {
+		return name
+	}
+	for i, w := range ws {
+		r, size := utf8.DecodeRuneInString(w)
+		ws[i] = string(unicode.ToUpper(r)) + strings.ToLower(w[size:])
+	}
+	return strings.Join(ws, " ")
+}
+
+// Score rates how likely two keys are to name the same merchant, from 0 to 1.
+// A key that starts with all the words of the other, as "starbucks coffee"
+// does "starbucks", scores at least Similarity.
+func Score(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 0
+	}
+	score := 1 - float64(distance(ra, rb))/float64(longest)
+	if strings.HasPrefix(a+" ", b+" ") || strings.HasPrefix(b+" ", a+" ") {
+		score = max(score, Similarity)
+	}
+	return score
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,96 @@
+package merchants
+
+import "testing"
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Starbucks", "starbucks"},
+		{"STARBUCKS #1234", "starbucks"},
+		{"  Starbucks  ", "starbucks"},
+		{"SQ *BLUE BOTTLE", "blue bottle"},
+		{"TST* Joe's Pizza", "joes pizza"},
+		{"PAYPAL *Etsy", "etsy"},
+		{"Amazon.com", "amazon"},
+		{"Acme Widgets, Inc.", "acme widgets"},
+		{"Acme Co LLC", "acme"},
+		{"Co", "co"},
+		{"Shell 12345678 04/05", "shell"},
+		{"Barnes & Noble", "barnes & noble"},
+		{"Café Ümlaut", "café ümlaut"},
+		{"#1234", ""},
+		{"12345", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Key(tt.title); got != tt.want {
+			t.Errorf("Key(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"STARBUCKS #1234", "Starbucks"},
+		{"SQ *BLUE BOTTLE COFFEE", "Blue Bottle Coffee"},
+		{"Blue Bottle", "Blue Bottle"},
+		{"iTunes Store", "iTunes Store"},
+		{"JOE'S PIZZA", "Joe's Pizza"},
+		{"ÉCOLE", "École"},
+		{"1234", ""},
+	}
+	for _, tt := range tests {
+		if got := DisplayName(tt.title); got != tt.want {
+			t.Errorf("DisplayName(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  string
+		match bool
+	}{
+		{name: "same key", a: "starbucks", b: "starbucks", match: true},
+		{name: "one typo", a: "starbucks", b: "starbuck", match: true},
+		{name: "extra words", a: "starbucks coffee", b: "starbucks", match: true},
+		{name: "extra words, either order", a: "starbucks", b: "starbucks reserve roastery", match: true},
+		{name: "prefix of a word", a: "star", b: "starbucks"},
+		{name: "different merchants", a: "shell", b: "chevron"},
+		{name: "short keys one letter apart", a: "bp", b: "bk"},
+		{name: "empty against a key", a: "", b: "shell"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score := Score(tt.a, tt.b)
+			if score < 0 || score > 1 {
+				t.Fatalf("Score(%q, %q) = %v, outside [0, 1]", tt.a, tt.b, score)
+			}
+			if (score >= Similarity) != tt.match {
+				t.Errorf("Score(%q, %q) = %v, want match %v", tt.a, tt.b, score, tt.match)
+			}
+			if rev := Score(tt.b, tt.a); rev != score {
+				t.Errorf("Score is not symmetric: %v and %v", score, rev)
+			}
+		})
+	}
+}
+
+func TestKeyPrefix(t *testing.T) {
+	tests := []struct{ key, want string }{
+		{"starbucks", "st"},
+		{"é", "é"},
+		{"ümlaut", "üm"},
+	}
+	for _, tt := range tests {
+		if got := keyPrefix(tt.key); got != tt.want {
+			t.Errorf("keyPrefix(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
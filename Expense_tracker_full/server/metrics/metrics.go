@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "expense_http_requests_total",
+		Help: "HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "expense_http_request_duration_seconds",
+		Help:    "HTTP request latency by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	mongoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "expense_mongo_command_duration_seconds",
+		Help:    "MongoDB command latency by command name.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"command"})
+
+	mongoErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "expense_mongo_command_errors_total",
+		Help: "MongoDB commands that failed, by command name.",
+	}, []string{"command"})
+)
+
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// Middleware records request counts and latency labelled by the route
+// template rather than the raw path so expense IDs don't explode cardinality.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		httpRequests.WithLabelValues(route, method, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// MongoMonitor times every command sent by the driver. onFailure, when set,
+// is called with the originating request context so failures can be logged
+// against the request that caused them.
+func MongoMonitor(onFailure func(ctx context.Context, command string, err string)) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			mongoDuration.WithLabelValues(e.CommandName).Observe(e.Duration.Seconds())
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			mongoDuration.WithLabelValues(e.CommandName).Observe(e.Duration.Seconds())
+			mongoErrors.WithLabelValues(e.CommandName).Inc()
+			if onFailure != nil {
+				onFailure(ctx, e.CommandName, e.Failure)
+			}
+		},
+	}
+}
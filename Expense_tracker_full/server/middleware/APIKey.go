@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"gin-app/problem"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeExport = "export"
+
+	APIKeyPrefix = "etk_"
+)
+
+var AllScopes = []string{ScopeRead, ScopeWrite, ScopeExport}
+
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"-"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"`
+	KeyHash    string             `bson:"key_hash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+func authenticateAPIKey(c *gin.Context, Collection *mongo.Collection, key string) {
+	var apiKey APIKey
+	now := time.Now()
+	err := Collection.FindOneAndUpdate(c.Request.Context(),
+		bson.M{"key_hash": HashToken(key), "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"last_used_at": now}},
+	).Decode(&apiKey)
+	if err == mongo.ErrNoDocuments {
+		problem.Abort(c, http.StatusUnauthorized, "Invalid or revoked API key")
+		return
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	c.Set("user_id", apiKey.UserID)
+	c.Set("auth_method", "api_key")
+	c.Set("scopes", apiKey.Scopes)
+	c.Next()
+}
+
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("auth_method") != "api_key" {
+			c.Next()
+			return
+		}
+		if !slices.Contains(c.GetStringSlice("scopes"), scope) {
+			problem.Abort(c, http.StatusForbidden, "API key lacks the "+scope+" scope")
+			return
+		}
+		c.Next()
+	}
+}
+
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("auth_method") != "session" {
+			problem.Abort(c, http.StatusForbidden, "This endpoint requires an interactive login")
+			return
+		}
+		c.Next()
+	}
+}
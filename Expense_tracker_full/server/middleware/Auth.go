@@ -1,7 +1,7 @@
 package middleware
 
 import (
-	"context"
+	"gin-app/problem"
 	"net/http"
 	"regexp"
 
@@ -13,6 +13,23 @@ import (
 
 var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
 
+func ValidEmail(email string) bool {
+	return emailRegex.MatchString(email)
+}
+
+func ValidatePassword(password, confirm string) string {
+	if password != confirm {
+		return "Passwords do not match"
+	}
+	if len(password) < 8 {
+		return "Password must be at least 8 characters long"
+	}
+	if len(password) > 20 {
+		return "Password must not exceed 20 characters"
+	}
+	return ""
+}
+
 func AuthMiddleware(Collection *mongo.Collection) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
@@ -21,38 +38,34 @@ func AuthMiddleware(Collection *mongo.Collection) gin.HandlerFunc {
 			ConfirmPassword string `json:"confirm_password"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid User"})
+			problem.Abort(c, http.StatusBadRequest, "Invalid User")
 			return
 		}
 		if !emailRegex.MatchString(req.Email) {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid email format"})
+			problem.Render(c, problem.New(http.StatusBadRequest, "Invalid email format").
+				WithCode(problem.CodeValidationFailed).
+				WithField("email", "must be a valid email address"))
 			return
 		}
-		count, err := Collection.CountDocuments(context.Background(), bson.M{"email": req.Email})
+		count, err := Collection.CountDocuments(c.Request.Context(), bson.M{"email": req.Email})
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Internal Server Error"})
+			problem.Abort(c, http.StatusInternalServerError, "Internal Server Error")
 			return
 		}
 		if count > 0 {
-			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "User already exists"})
-			return
-		}
-		if req.Password != req.ConfirmPassword {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Passwords do not match"})
-			return
-		}
-		if len(req.Password) < 8 {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Password must be at least 8 characters long"})
+			problem.Render(c, problem.New(http.StatusConflict, "User already exists").WithCode("email_taken"))
 			return
 		}
-		if len(req.Password) > 20 {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Password must not exceed 20 characters"})
+		if msg := ValidatePassword(req.Password, req.ConfirmPassword); msg != "" {
+			problem.Render(c, problem.New(http.StatusBadRequest, msg).
+				WithCode(problem.CodeValidationFailed).
+				WithField("password", msg))
 			return
 		}
 
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Error hashing password"})
+			problem.Abort(c, http.StatusInternalServerError, "Error hashing password")
 			return
 		}
 		c.Set("email", req.Email)
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"gin-app/problem"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleViewer = "viewer"
+)
+
+var AllRoles = []string{RoleAdmin, RoleMember, RoleViewer}
+
+// AccountMiddleware runs after SessionMiddleware and loads the caller's role,
+// rejecting accounts an admin has disabled. Users created before roles
+// existed have no role stored and are treated as members. It also records the
+// caller's personal workspace for WorkspaceMiddleware.
+func AccountMiddleware(Collection *mongo.Collection) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := c.Get("user_id")
+		var account struct {
+			Role               string             `bson:"role"`
+			Disabled           bool               `bson:"disabled"`
+			DefaultWorkspaceID primitive.ObjectID `bson:"default_workspace_id"`
+		}
+		opts := options.FindOne().SetProjection(bson.M{"role": 1, "disabled": 1, "default_workspace_id": 1})
+		err := Collection.FindOne(c.Request.Context(), bson.M{"_id": id.(primitive.ObjectID)}, opts).Decode(&account)
+		if err == mongo.ErrNoDocuments {
+			problem.Abort(c, http.StatusUnauthorized, "Account no longer exists")
+			return
+		}
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		if account.Disabled {
+			problem.Render(c, problem.New(http.StatusForbidden, "Account is disabled").WithCode("account_disabled"))
+			return
+		}
+		if account.Role == "" {
+			account.Role = RoleMember
+		}
+		c.Set("role", account.Role)
+		c.Set("default_workspace_id", account.DefaultWorkspaceID)
+		c.Next()
+	}
+}
+
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !slices.Contains(roles, c.GetString("role")) {
+			problem.Abort(c, http.StatusForbidden, "Insufficient permissions")
+			return
+		}
+		c.Next()
+	}
+}
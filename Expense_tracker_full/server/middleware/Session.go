@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"gin-app/problem"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Session is a login session, or a pending two-factor challenge.
+// ReauthenticatedAt is when the user last signed in with their identity
+// provider to obtain it; it is unset for password logins.
+type Session struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty"`
+	UserID            primitive.ObjectID `bson:"user_id"`
+	TokenHash         string             `bson:"token_hash"`
+	Pending2FA        bool               `bson:"pending_2fa,omitempty"`
+	ReauthenticatedAt *time.Time         `bson:"reauthenticated_at,omitempty"`
+	CreatedAt         time.Time          `bson:"created_at"`
+	ExpiresAt         time.Time          `bson:"expires_at"`
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func BearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// SessionMiddleware authenticates either a login session token or an API
+// key, sent as X-API-Key or as a bearer token carrying the API key prefix.
+func SessionMiddleware(Collection *mongo.Collection, APIKeys *mongo.Collection) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := c.GetHeader("X-API-Key"); key != "" {
+			authenticateAPIKey(c, APIKeys, key)
+			return
+		}
+		token := BearerToken(c)
+		if strings.HasPrefix(token, APIKeyPrefix) {
+			authenticateAPIKey(c, APIKeys, token)
+			return
+		}
+		if token == "" {
+			problem.Abort(c, http.StatusUnauthorized, "Missing session token")
+			return
+		}
+		var session Session
+		err := Collection.FindOne(c.Request.Context(), bson.M{
+			"token_hash":  HashToken(token),
+			"pending_2fa": bson.M{"$ne": true},
+			"expires_at":  bson.M{"$gt": time.Now()},
+		}).Decode(&session)
+		if err == mongo.ErrNoDocuments {
+			problem.Abort(c, http.StatusUnauthorized, "Invalid or expired session")
+			return
+		}
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		c.Set("user_id", session.UserID)
+		c.Set("auth_method", "session")
+		c.Next()
+	}
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"gin-app/problem"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const WorkspaceHeader = "X-Workspace-ID"
+
+const (
+	WorkspaceOwner  = "owner"
+	WorkspaceEditor = "editor"
+	WorkspaceViewer = "viewer"
+)
+
+var AllWorkspaceRoles = []string{WorkspaceOwner, WorkspaceEditor, WorkspaceViewer}
+
+// WorkspaceMiddleware runs after AccountMiddleware and resolves the workspace
+// the request acts on: the one named by the X-Workspace-ID header, or the
+// caller's personal workspace when the header is absent. Workspaces the
+// caller is not a member of are reported as missing so ids can't be probed.
+func WorkspaceMiddleware(Collection *mongo.Collection) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.MustGet("user_id").(primitive.ObjectID)
+		var workspaceID primitive.ObjectID
+		if header := c.GetHeader(WorkspaceHeader); header != "" {
+			id, err := primitive.ObjectIDFromHex(header)
+			if err != nil {
+				problem.Render(c, problem.New(http.StatusBadRequest, "Invalid workspace ID").
+					WithCode(problem.CodeValidationFailed).
+					WithField(WorkspaceHeader, "must be a workspace ID"))
+				return
+			}
+			workspaceID = id
+		} else {
+			id, _ := c.Get("default_workspace_id")
+			workspaceID, _ = id.(primitive.ObjectID)
+			if workspaceID.IsZero() {
+				problem.Render(c, problem.New(http.StatusBadRequest, "No workspace selected; send "+WorkspaceHeader).WithCode("workspace_required"))
+				return
+			}
+		}
+		var workspace struct {
+			Members []struct {
+				Role string `bson:"role"`
+			} `bson:"members"`
+		}
+		opts := options.FindOne().SetProjection(bson.M{"members": bson.M{"$elemMatch": bson.M{"user_id": userID}}})
+		err := Collection.FindOne(c.Request.Context(), bson.M{"_id": workspaceID, "members.user_id": userID}, opts).Decode(&workspace)
+		if err == mongo.ErrNoDocuments || (err == nil && len(workspace.Members) == 0) {
+			problem.Render(c, problem.New(http.StatusNotFound, "Workspace not found").WithCode("workspace_not_found"))
+			return
+		}
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		c.Set("workspace_id", workspaceID)
+		c.Set("workspace_role", workspace.Members[0].Role)
+		c.Next()
+	}
+}
+
+// RequireWorkspaceRole checks the caller's role in the selected workspace;
+// it complements RequireRole, which checks the account-wide role.
+func RequireWorkspaceRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !slices.Contains(roles, c.GetString("workspace_role")) {
+			problem.Abort(c, http.StatusForbidden, "Insufficient workspace permissions")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,521 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gin-app/config"
+	"gin-app/merchants"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExpenseSchemaVersion is stamped on every expense document written by the
+// current code. Bump it together with a migration that upgrades older ones.
+const ExpenseSchemaVersion = 1
+
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database, cols config.Collections) error
+}
+
+type appliedMigration struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// errDeferred tells Run that a migration can't finish until the data allows
+// it, so it is left unrecorded and tried again on the next start.
+var errDeferred = errors.New("migration deferred")
+
+// All lists migrations in the order they run. Each must be safe to re-run,
+// since two instances starting together may both apply it.
+var All = []Migration{
+	{1, "create indexes", createIndexes},
+	{2, "normalise expense field types", normaliseExpenses},
+	{3, "backfill user profile defaults", backfillUsers},
+	{4, "create income and savings goal indexes", createIncomeIndexes},
+	{5, "backfill expense tags and index saved searches", backfillTags},
+	{6, "create statement run indexes", createStatementIndexes},
+	{7, "backfill category statistics for anomaly detection", backfillCategoryStats},
+	{8, "move expenses and budgets into personal workspaces", backfillWorkspaces},
+	{9, "backfill expense types", backfillExpenseTypes},
+	{10, "backfill expense approval status", backfillExpenseStatus},
+	{11, "backfill sync revisions and index the change feed", backfillSyncRevisions},
+	{12, "index deleted accounts for the retention job", createRetentionIndexes},
+	{13, "create merchants and match existing expenses to them", backfillMerchants},
+	{14, "assign expenses without an owner to the first admin", adoptOwnerlessExpenses},
+}
+
+func Run(ctx context.Context, db *mongo.Database, cols config.Collections) error {
+	applied := db.Collection("schema_migrations")
+	cur, err := applied.Find(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("list applied migrations: %w", err)
+	}
+	var done []appliedMigration
+	if err := cur.All(ctx, &done); err != nil {
+		return fmt.Errorf("list applied migrations: %w", err)
+	}
+	seen := make(map[int]bool, len(done))
+	for _, m := range done {
+		seen[m.Version] = true
+	}
+
+	for _, m := range All {
+		if seen[m.Version] {
+			continue
+		}
+		slog.Info("applying migration", "version", m.Version, "description", m.Description)
+		err := m.Up(ctx, db, cols)
+		if errors.Is(err, errDeferred) {
+			slog.Warn("migration deferred to the next start", "version", m.Version, "description", m.Description)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		_, err = applied.UpdateOne(ctx, bson.M{"_id": m.Version}, bson.M{"$setOnInsert": appliedMigration{
+			Version:     m.Version,
+			Description: m.Description,
+			AppliedAt:   time.Now(),
+		}}, options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("record migration %d: %w", m.Version, err)
+		}
+	}
+	return nil
+}
+
+func createIndexes(ctx context.Context, db *mongo.Database, cols config.Collections) error {
+	indexes := map[string][]mongo.IndexModel{
+		cols.Expenses: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "date", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "category", Value: 1}}},
+		},
+		cols.Users: {
+			{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "identities.issuer", Value: 1}, {Key: "identities.subject", Value: 1}}},
+		},
+		cols.Sessions: {
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		cols.APIKeys: {
+			{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		},
+		cols.OIDCState: {
+			{Keys: bson.D{{Key: "state", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+	}
+	return ensureIndexes(ctx, db, indexes)
+}
+
+func ensureIndexes(ctx context.Context, db *mongo.Database, indexes map[string][]mongo.IndexModel) error {
+	for name, models := range indexes {
+		if _, err := db.Collection(name).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func convert(field, to string, fallback any) bson.M {
+	return bson.M{"$convert": bson.M{
+		"input":   "$" + field,
+		"to":      to,
+		"onError": fallback,
+		"onNull":  fallback,
+	}}
+}
+
+// normaliseExpenses repairs documents written before typed decoding: integer
+// or string amounts, missing text fields and missing dates, which used to
+// panic the list handler.
+func normaliseExpenses(ctx context.Context, db *mongo.Database, cols config.Collections) error {
+	_, err := db.Collection(cols.Expenses).UpdateMany(ctx,
+		bson.M{"schema_version": bson.M{"$not": bson.M{"$gte": ExpenseSchemaVersion}}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"amount":         convert("amount", "double", 0.0),
+			"title":          convert("title", "string", ""),
+			"category":       convert("category", "string", ""),
+			"description":    convert("description", "string", ""),
+			"date":           convert("date", "date", bson.M{"$toDate": "$_id"}),
+			"schema_version": ExpenseSchemaVersion,
+		}}}},
+	)
+	return err
+}
+
+func backfillUsers(ctx context.Context, db *mongo.Database, cols config.Collections) error {
+	defaults := map[string]any{
+		"role":          "member",
+		"disabled":      false,
+		"display_name":  "",
+		"base_currency": "USD",
+		"timezone":      "UTC",
+	}
+	users := db.Collection(cols.Users)
+	for field, value := range defaults {
+		_, err := users.UpdateMany(ctx,
+			bson.M{field: bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{field: value}},
+		)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+	}
+	return nil
+}
+
+func createIncomeIndexes(ctx context.Context, db *mongo.Database, cols config.Collections) error {
+	return ensureIndexes(ctx, db, map[string][]mongo.IndexModel{
+		cols.Income: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "date", Value: -1}}},
+		},
+		cols.Goals: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		},
+	})
+}
+
+func backfillTags(ctx context.Context, db *mongo.Database, cols config.Collections) error {
+	_, err := db.Collection(cols.Expenses).UpdateMany(ctx,
+		bson.M{"tags": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"tags": bson.A{}}},
+	)
+	if err != nil {
+		return err
+	}
+	return ensureIndexes(ctx, db, map[string][]mongo.IndexModel{
+		cols.Expenses: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "tags", Value: 1}}},
+		},
+		cols.SavedSearches: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}}},
+		},
+	})
+}
+
+// createStatementIndexes makes (user_id, period) unique; the scheduler relies
+// on the duplicate key error to avoid sending a statement twice.
+func createStatementIndexes(ctx context.Context, db *mongo.Database, cols config.Collections) error {
+	return ensureIndexes(ctx, db, map[string][]mongo.IndexModel{
+		cols.Statements: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "period", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+	})
+}
+
+// backfillCategoryStats seeds the running per-category sums from existing
+// expenses. The $merge replaces whole documents, so re-running it converges
+// on the same totals.
+func backfillCategoryStats(ctx context.Context, db *mongo.Database, cols config.Collections) error {
+	err := ensureIndexes(ctx, db, map[string][]mongo.IndexModel{
+		cols.CategoryStats: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "category", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		cols.Anomalies: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "expense_id", Value: 1}}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return mergeCategoryStats(ctx, db, cols, "user_id")
+}
+
+// mergeCategoryStats totals expenses per owner field and category into the
+// category statistics collection.
+func mergeCategoryStats(ctx context.Context, db *mongo.Database, cols config.Collections, owner string) error {
+	cur, err := db.Collection(cols.Expenses).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":    bson.M{"owner": "$" + owner, "category": "$category"},
+			"count":  bson.M{"$sum": 1},
+			"sum":    bson.M{"$sum": "$amount"},
+			"sum_sq": bson.M{"$sum": bson.M{"$multiply": bson.A{"$amount", "$amount"}}},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":      0,
+			owner:      "$_id.owner",
+			"category": "$_id.category",
+			"count":    1,
+			"sum":      1,
+			"sum_sq":   1,
+		}}},
+		{{Key: "$merge", Value: bson.M{
+			"into":           cols.CategoryStats,
+			"on":             bson.A{owner, "category"},
+			"whenMatched":    "replace",
+			"whenNotMatched": "insert",
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	return cur.Close(ctx)
+}
+
+// backfillWorkspaces gives every user a personal workspace holding their
+// budgets, expenses and anomalies. Category statistics were kept per user, so
+// they are rebuilt per workspace. The unique personal-workspace index makes
+// the upsert safe to re-run.
+func backfillWorkspaces(ctx context.Context, db *mongo.Database, cols config.Collections) error {
+	err := ensureIndexes(ctx, db, map[string][]mongo.IndexModel{
+		cols.Workspaces: {
+			{Keys: bson.D{{Key: "owner_id", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"personal": true})},
+			{Keys: bson.D{{Key: "members.user_id", Value: 1}}},
+		},
+		cols.Invitations: {
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "status", Value: 1}}},
+		},
+		cols.Expenses: {
+			{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "date", Value: -1}}},
+			{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "category", Value: 1}}},
+			{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "tags", Value: 1}}},
+		},
+		cols.Anomalies: {
+			{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	users := db.Collection(cols.Users)
+	workspaces := db.Collection(cols.Workspaces)
+	cur, err := users.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"budgets": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var user struct {
+			ID      any                `bson:"_id"`
+			Budgets map[string]float64 `bson:"budgets"`
+		}
+		if err := cur.Decode(&user); err != nil {
+			return err
+		}
+		if user.Budgets == nil {
+			user.Budgets = map[string]float64{}
+		}
+		now := time.Now()
+		var workspace struct {
+			ID any `bson:"_id"`
+		}
+		err := workspaces.FindOneAndUpdate(ctx,
+			bson.M{"owner_id": user.ID, "personal": true},
+			bson.M{"$setOnInsert": bson.M{
+				"name":       "Personal",
+				"members":    bson.A{bson.M{"user_id": user.ID, "role": "owner", "joined_at": now}},
+				"budgets":    user.Budgets,
+				"created_at": now,
+				"updated_at": now,
+			}},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&workspace)
+		if err != nil {
+			return fmt.Errorf("personal workspace: %w", err)
+		}
+		_, err = users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+			"$set":   bson.M{"default_workspace_id": workspace.ID},
+			"$unset": bson.M{"budgets": ""},
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{cols.Expenses, cols.Anomalies} {
+			_, err := db.Collection(name).UpdateMany(ctx,
+				bson.M{"user_id": user.ID, "workspace_id": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"workspace_id": workspace.ID}},
+			)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	if err := db.Collection(cols.CategoryStats).Drop(ctx); err != nil {
+		return err
+	}
+	err = ensureIndexes(ctx, db, map[string][]mongo.IndexModel{
+		cols.CategoryStats: {
+			{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "category", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return mergeCategoryStats(ctx, db, cols, "workspace_id")
+}
+
+// backfillExpenseTypes marks existing expenses as standard; mileage and per
+// diem expenses only exist from this version on.
+func backfillExpenseTypes(ctx context.Context, db *mongo.Database, cols config.Collections) error {
+	_, err := db.Collection(cols.Expenses).UpdateMany(ctx,
+		bson.M{"type": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"type": "standard"}},
+	)
+	return err
+}
+
+func backfillExpenseStatus(ctx context.Context, db *mongo.Database, cols config.Collections) error {
+	_, err := db.Collection(cols.Expenses).UpdateMany(ctx,
+		bson.M{"status": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"status": "draft"}},
+	)
+	if err != nil {
+		return err
+	}
+	return ensureIndexes(ctx, db, map[string][]mongo.IndexModel{
+		cols.Expenses: {
+			{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "approver_id", Value: 1}, {Key: "status", Value: 1}}},
+		},
+	})
+}
+
+// backfillSyncRevisions gives existing expenses revision 1 and change sequence
+// 1, and starts every workspace's sequence at 1 or above, so the first pull
+// from checkpoint 0 returns them and later writes sort after them.
+func backfillSyncRevisions(ctx context.Context, db *mongo.Database, cols config.Collections) error {
+	_, err := db.Collection(cols.Expenses).UpdateMany(ctx,
+		bson.M{"revision": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revision": 1, "sync_seq": 1}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection(cols.Workspaces).UpdateMany(ctx, bson.M{}, bson.M{"$max": bson.M{"sync_seq": 1}})
+	if err != nil {
+		return err
+	}
+	return ensureIndexes(ctx, db, map[string][]mongo.IndexModel{
+		cols.Expenses: {
+			{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "sync_seq", Value: 1}}},
+		},
+		cols.Tombstones: {
+			{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "sync_seq", Value: 1}}},
+		},
+	})
+}
+
+func createRetentionIndexes(ctx context.Context, db *mongo.Database, cols config.Collections) error {
+	return ensureIndexes(ctx, db, map[string][]mongo.IndexModel{
+		cols.Users: {
+			{Keys: bson.D{{Key: "deleted_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+		},
+	})
+}
+
+// backfillMerchants resolves a merchant for every expense that has none yet.
+// The change feed isn't bumped, so offline clients pick up merchant_id with
+// each expense's next change.
+func backfillMerchants(ctx context.Context, db *mongo.Database, cols config.Collections) error {
+	err := ensureIndexes(ctx, db, map[string][]mongo.IndexModel{
+		cols.Merchants: {
+			{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "aliases", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "name", Value: 1}}},
+		},
+		cols.Expenses: {
+			{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "merchant_id", Value: 1}}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	expenses := db.Collection(cols.Expenses)
+	opts := options.Find().SetProjection(bson.M{"workspace_id": 1, "title": 1})
+	cur, err := expenses.Find(ctx, bson.M{"merchant_id": bson.M{"$exists": false}}, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var e struct {
+			ID          primitive.ObjectID `bson:"_id"`
+			WorkspaceID primitive.ObjectID `bson:"workspace_id"`
+			Title       string             `bson:"title"`
+		}
+		if err := cur.Decode(&e); err != nil || e.WorkspaceID.IsZero() {
+			continue
+		}
+		merchantID, err := merchants.Resolve(ctx, db.Collection(cols.Merchants), e.WorkspaceID, e.Title)
+		if err != nil {
+			return err
+		}
+		if merchantID.IsZero() {
+			continue
+		}
+		if _, err := expenses.UpdateOne(ctx, bson.M{"_id": e.ID}, bson.M{"$set": bson.M{"merchant_id": merchantID}}); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// adoptOwnerlessExpenses hands expenses recorded before accounts existed, and
+// so without a user_id, to the first admin, or the first user when there is
+// no admin. backfillWorkspaces skipped them, leaving them in no workspace and
+// visible to nobody. With no user yet it is deferred, and runs again once
+// someone has signed up and the server restarts.
+func adoptOwnerlessExpenses(ctx context.Context, db *mongo.Database, cols config.Collections) error {
+	expenses := db.Collection(cols.Expenses)
+	ownerless := bson.M{"user_id": nil}
+	n, err := expenses.CountDocuments(ctx, ownerless)
+	if err != nil || n == 0 {
+		return err
+	}
+
+	var owner struct {
+		ID                 primitive.ObjectID `bson:"_id"`
+		DefaultWorkspaceID primitive.ObjectID `bson:"default_workspace_id"`
+	}
+	users := db.Collection(cols.Users)
+	opts := options.FindOne().SetSort(bson.M{"_id": 1})
+	err = users.FindOne(ctx, bson.M{"role": "admin"}, opts).Decode(&owner)
+	if err == mongo.ErrNoDocuments {
+		err = users.FindOne(ctx, bson.M{}, opts).Decode(&owner)
+	}
+	if err == mongo.ErrNoDocuments || (err == nil && owner.DefaultWorkspaceID.IsZero()) {
+		slog.Warn("expenses without an owner stay hidden until a user exists to assign them to", "count", n)
+		return errDeferred
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = expenses.UpdateMany(ctx,
+		bson.M{"user_id": nil, "workspace_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"workspace_id": owner.DefaultWorkspaceID}},
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := expenses.UpdateMany(ctx, ownerless, bson.M{"$set": bson.M{"user_id": owner.ID}}); err != nil {
+		return err
+	}
+	slog.Warn("assigned expenses without an owner", "count", n, "user_id", owner.ID.Hex(), "workspace_id", owner.DefaultWorkspaceID.Hex())
+	// They had no workspace when category statistics were rebuilt and
+	// merchants were matched.
+	if err := mergeCategoryStats(ctx, db, cols, "workspace_id"); err != nil {
+		return err
+	}
+	return backfillMerchants(ctx, db, cols)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"gin-app/config"
+	"gin-app/middleware"
+	"gin-app/problem"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/oauth2"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+type ExternalIdentity struct {
+	Issuer   string    `bson:"issuer" json:"issuer"`
+	Subject  string    `bson:"subject" json:"subject"`
+	LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
+}
+
+type oidcLoginState struct {
+	State     string    `bson:"state"`
+	Verifier  string    `bson:"verifier"`
+	Nonce     string    `bson:"nonce"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Nonce         string `json:"nonce"`
+	AuthTime      int64  `json:"auth_time"`
+}
+
+var (
+	OIDCStateCollection *mongo.Collection
+	oidcConfig          config.OIDCConfig
+
+	oidcMu       sync.Mutex
+	oidcProvider *oidc.Provider
+)
+
+// provider discovers the issuer lazily so the server still starts when the
+// identity provider is briefly unreachable.
+func provider(ctx context.Context) (*oidc.Provider, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if oidcProvider != nil {
+		return oidcProvider, nil
+	}
+	p, err := oidc.NewProvider(ctx, oidcConfig.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	oidcProvider = p
+	return p, nil
+}
+
+func oauthConfig(p *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     oidcConfig.ClientID,
+		ClientSecret: oidcConfig.ClientSecret,
+		RedirectURL:  oidcConfig.RedirectURL,
+		Endpoint:     p.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func oidcEnabled(c *gin.Context) bool {
+	if oidcConfig.Issuer == "" || oidcConfig.ClientID == "" {
+		problem.Abort(c, http.StatusNotFound, "Single sign-on is not configured")
+		return false
+	}
+	return true
+}
+
+func OIDCLogin(c *gin.Context) {
+	ctx := c.Request.Context()
+	if !oidcEnabled(c) {
+		return
+	}
+	p, err := provider(ctx)
+	if err != nil {
+		problem.Abort(c, http.StatusBadGateway, "Identity provider unavailable")
+		return
+	}
+	state, err := randomString(16)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+	nonce, err := randomString(16)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+	_, err = OIDCStateCollection.InsertOne(ctx, oidcLoginState{
+		State:     state,
+		Verifier:  verifier,
+		Nonce:     nonce,
+		ExpiresAt: time.Now().Add(oidcStateTTL),
+	})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+	authURL := oauthConfig(p).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+func OIDCCallback(c *gin.Context) {
+	ctx := c.Request.Context()
+	if !oidcEnabled(c) {
+		return
+	}
+	if msg := c.Query("error"); msg != "" {
+		problem.Abort(c, http.StatusUnauthorized, "Identity provider rejected login: "+msg)
+		return
+	}
+	var st oidcLoginState
+	err := OIDCStateCollection.FindOneAndDelete(ctx, bson.M{
+		"state":      c.Query("state"),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&st)
+	if err == mongo.ErrNoDocuments {
+		problem.Abort(c, http.StatusBadRequest, "Invalid or expired login state")
+		return
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to verify login state")
+		return
+	}
+	p, err := provider(ctx)
+	if err != nil {
+		problem.Abort(c, http.StatusBadGateway, "Identity provider unavailable")
+		return
+	}
+	tok, err := oauthConfig(p).Exchange(ctx, c.Query("code"), oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		problem.Abort(c, http.StatusUnauthorized, "Failed to exchange authorization code")
+		return
+	}
+	rawIDToken, ok := tok.Extra("id_token").(string)
+	if !ok {
+		problem.Abort(c, http.StatusUnauthorized, "Identity provider returned no ID token")
+		return
+	}
+	idToken, err := p.Verifier(&oidc.Config{ClientID: oidcConfig.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		problem.Abort(c, http.StatusUnauthorized, "Invalid ID token")
+		return
+	}
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		problem.Abort(c, http.StatusUnauthorized, "Invalid ID token claims")
+		return
+	}
+	if claims.Nonce != st.Nonce {
+		problem.Abort(c, http.StatusUnauthorized, "ID token nonce mismatch")
+		return
+	}
+	userID, err := linkIdentity(ctx, idToken.Issuer, idToken.Subject, claims)
+	if errors.Is(err, errEmailRequired) {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Identity provider returned no email address; grant the email scope to sign up").
+			WithCode("email_required"))
+		return
+	}
+	if errors.Is(err, errEmailTaken) {
+		problem.Abort(c, http.StatusConflict, "An account with this email exists; verify the email with your identity provider to link it")
+		return
+	}
+	if errors.Is(err, errEmailAmbiguous) {
+		problem.Abort(c, http.StatusConflict, "More than one account uses this email address; sign in with a password instead")
+		return
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to link identity")
+		return
+	}
+	var user SignupUser
+	if err := UserDataCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch user")
+		return
+	}
+	if user.Disabled {
+		problem.Render(c, problem.New(http.StatusForbidden, "Account is disabled").WithCode("account_disabled"))
+		return
+	}
+	// The provider may have reused an earlier sign-in; auth_time says when
+	// the user last actually authenticated.
+	reauthenticatedAt := time.Now()
+	if authTime := time.Unix(claims.AuthTime, 0); claims.AuthTime > 0 && authTime.Before(reauthenticatedAt) {
+		reauthenticatedAt = authTime
+	}
+	// Single sign-on replaces the password, not the second factor.
+	if user.TOTPEnabled {
+		challenge, err := createTwoFactorChallenge(ctx, user.ID, &reauthenticatedAt)
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Failed to create two-factor challenge")
+			return
+		}
+		if oidcConfig.PostLoginURL != "" {
+			fragment := url.Values{"two_factor_required": {"true"}, "two_factor_token": {challenge}}
+			c.Redirect(http.StatusFound, oidcConfig.PostLoginURL+"#"+fragment.Encode())
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"two_factor_required": true, "two_factor_token": challenge})
+		return
+	}
+	token, expiresAt, err := createSession(ctx, user.ID, &reauthenticatedAt)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to create session")
+		return
+	}
+	if oidcConfig.PostLoginURL != "" {
+		fragment := url.Values{"token": {token}, "expires_at": {expiresAt.Format(time.RFC3339)}}
+		c.Redirect(http.StatusFound, oidcConfig.PostLoginURL+"#"+fragment.Encode())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "expires_at": expiresAt})
+}
+
+var (
+	errEmailTaken     = errors.New("email already registered")
+	errEmailRequired  = errors.New("no email claim")
+	errEmailAmbiguous = errors.New("email registered more than once in different cases")
+)
+
+// findUserByEmailFold finds the account for email ignoring case, since
+// identity providers don't preserve the case an address was signed up with.
+// An exact match wins over accounts whose address differs only in case.
+func findUserByEmailFold(ctx context.Context, email string) (SignupUser, error) {
+	var users []SignupUser
+	filter := bson.M{"email": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(email) + "$", Options: "i"}}
+	cur, err := UserDataCollection.Find(ctx, filter)
+	if err != nil {
+		return SignupUser{}, err
+	}
+	if err := cur.All(ctx, &users); err != nil {
+		return SignupUser{}, err
+	}
+	for _, user := range users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	switch len(users) {
+	case 0:
+		return SignupUser{}, mongo.ErrNoDocuments
+	case 1:
+		return users[0], nil
+	}
+	return SignupUser{}, errEmailAmbiguous
+}
+
+// linkIdentity resolves an external identity to a user_data record. A known
+// issuer/subject pair wins; otherwise a verified email links to the existing
+// account, and an unknown email creates a password-less account. A new
+// account needs an email, which is the unique key for users.
+func linkIdentity(ctx context.Context, issuer, subject string, claims oidcClaims) (primitive.ObjectID, error) {
+	var user SignupUser
+	err := UserDataCollection.FindOne(ctx, bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"issuer": issuer, "subject": subject}},
+	}).Decode(&user)
+	if err == nil {
+		return user.ID, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return primitive.NilObjectID, err
+	}
+
+	identity := ExternalIdentity{Issuer: issuer, Subject: subject, LinkedAt: time.Now()}
+	email := strings.TrimSpace(claims.Email)
+	if email != "" {
+		user, err = findUserByEmailFold(ctx, email)
+		if err == nil {
+			if !claims.EmailVerified {
+				return primitive.NilObjectID, errEmailTaken
+			}
+			_, err = UserDataCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+				"$push": bson.M{"identities": identity},
+				"$set":  bson.M{"updated_at": time.Now()},
+			})
+			return user.ID, err
+		}
+		if err != mongo.ErrNoDocuments {
+			return primitive.NilObjectID, err
+		}
+	}
+
+	if email == "" {
+		return primitive.NilObjectID, errEmailRequired
+	}
+	// An unverified address proves nothing, so it can't earn the admin role.
+	role := middleware.RoleMember
+	if claims.EmailVerified {
+		role = roleForEmail(email)
+	}
+	res, err := UserDataCollection.InsertOne(ctx, bson.M{
+		"email":         email,
+		"password":      "",
+		"display_name":  claims.Name,
+		"role":          role,
+		"disabled":      false,
+		"base_currency": defaultCurrency,
+		"timezone":      defaultTimezone,
+		"identities":    []ExternalIdentity{identity},
+		"created_at":    time.Now(),
+		"updated_at":    time.Now(),
+	})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	userID := res.InsertedID.(primitive.ObjectID)
+	if _, err := createPersonalWorkspace(ctx, userID); err != nil {
+		return primitive.NilObjectID, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"gin-app/config"
+	"gin-app/stubidp"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/oauth2"
+)
+
+// startSSO serves the app and a stub identity provider signing in email, and
+// points the app's single sign-on at the provider.
+func startSSO(t *testing.T, email string) (*httptest.Server, *stubidp.Provider) {
+	t.Helper()
+	app := testServer(t)
+	idp, err := stubidp.New("", email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	idp.Routes(r)
+	idpServer := httptest.NewServer(r)
+	t.Cleanup(idpServer.Close)
+	idp.Issuer = idpServer.URL
+
+	oidcConfig = config.OIDCConfig{
+		Issuer:       idpServer.URL,
+		ClientID:     "expense-tracker",
+		ClientSecret: "secret",
+		RedirectURL:  app.URL + "/auth/oidc/callback",
+	}
+	oidcProvider = nil
+	t.Cleanup(func() {
+		oidcConfig = config.OIDCConfig{}
+		oidcProvider = nil
+	})
+	return app, idp
+}
+
+// signIn follows the login redirect to the provider and its redirect back to
+// the callback, calling tamper with the login state just before the callback.
+func signIn(t *testing.T, app *httptest.Server, tamper func(state string)) *http.Response {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	redirect := func(target string) string {
+		t.Helper()
+		res, err := client.Get(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusFound {
+			t.Fatalf("GET %s: status %d, want 302", target, res.StatusCode)
+		}
+		return res.Header.Get("Location")
+	}
+	authorize := redirect(app.URL + "/auth/oidc/login")
+	callback := redirect(authorize)
+	if tamper != nil {
+		u, err := url.Parse(callback)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tamper(u.Query().Get("state"))
+	}
+	res, err := client.Get(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+func setLoginState(t *testing.T, state string, set bson.M) {
+	t.Helper()
+	res, err := OIDCStateCollection.UpdateOne(context.Background(), bson.M{"state": state}, bson.M{"$set": set})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.MatchedCount != 1 {
+		t.Fatalf("no login state %q", state)
+	}
+}
+
+func findUserByEmail(t *testing.T, email string) SignupUser {
+	t.Helper()
+	var user SignupUser
+	if err := UserDataCollection.FindOne(context.Background(), bson.M{"email": email}).Decode(&user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func insertPasswordUser(t *testing.T, email string) {
+	t.Helper()
+	_, err := UserDataCollection.InsertOne(context.Background(), bson.M{
+		"email":      email,
+		"password":   "$2a$10$abcdefghijklmnopqrstuv",
+		"role":       "member",
+		"disabled":   false,
+		"created_at": time.Now(),
+		"updated_at": time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCSignUp(t *testing.T) {
+	app, _ := startSSO(t, "new@example.com")
+	res := signIn(t, app, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("callback: status %d, want 200", res.StatusCode)
+	}
+	var session struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&session); err != nil || session.Token == "" {
+		t.Fatalf("callback returned no token (%v)", err)
+	}
+
+	user := findUserByEmail(t, "new@example.com")
+	if user.Password != "" || len(user.Identities) != 1 || user.DefaultWorkspaceID.IsZero() {
+		t.Fatalf("user = %+v, want a password-less account with one identity and a workspace", user)
+	}
+	req, _ := http.NewRequest(http.MethodGet, app.URL+"/me", nil)
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	me, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer me.Body.Close()
+	if me.StatusCode != http.StatusOK {
+		t.Fatalf("GET /me with the new session: status %d", me.StatusCode)
+	}
+}
+
+func TestOIDCRejectsWrongVerifier(t *testing.T) {
+	app, _ := startSSO(t, "pkce@example.com")
+	res := signIn(t, app, func(state string) {
+		setLoginState(t, state, bson.M{"verifier": oauth2.GenerateVerifier()})
+	})
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", res.StatusCode)
+	}
+}
+
+func TestOIDCRejectsNonceMismatch(t *testing.T) {
+	app, _ := startSSO(t, "nonce@example.com")
+	res := signIn(t, app, func(state string) {
+		setLoginState(t, state, bson.M{"nonce": "replayed"})
+	})
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", res.StatusCode)
+	}
+	n, err := UserDataCollection.CountDocuments(context.Background(), bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("%d users created from a token with the wrong nonce", n)
+	}
+}
+
+func TestOIDCRejectsExpiredState(t *testing.T) {
+	app, _ := startSSO(t, "late@example.com")
+	res := signIn(t, app, func(state string) {
+		setLoginState(t, state, bson.M{"expires_at": time.Now().Add(-time.Second)})
+	})
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", res.StatusCode)
+	}
+}
+
+func TestOIDCLinksVerifiedEmail(t *testing.T) {
+	app, _ := startSSO(t, "existing@example.com")
+	insertPasswordUser(t, "existing@example.com")
+	res := signIn(t, app, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200", res.StatusCode)
+	}
+	user := findUserByEmail(t, "existing@example.com")
+	if len(user.Identities) != 1 || user.Password == "" {
+		t.Fatalf("user = %+v, want the existing account with the identity linked", user)
+	}
+}
+
+func TestOIDCRefusesUnverifiedEmailOfExistingAccount(t *testing.T) {
+	app, idp := startSSO(t, "taken@example.com")
+	idp.EmailVerified = false
+	insertPasswordUser(t, "taken@example.com")
+	res := signIn(t, app, nil)
+	if res.StatusCode != http.StatusConflict {
+		t.Fatalf("status %d, want 409", res.StatusCode)
+	}
+	if user := findUserByEmail(t, "taken@example.com"); len(user.Identities) != 0 {
+		t.Fatalf("identity linked through an unverified email: %+v", user.Identities)
+	}
+}
+
+func TestOIDCRequiresEmail(t *testing.T) {
+	app, _ := startSSO(t, "")
+	res := signIn(t, app, nil)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", res.StatusCode)
+	}
+}
+
+// Providers report addresses in whatever case they like.
+func TestOIDCLinksEmailIgnoringCase(t *testing.T) {
+	app, _ := startSSO(t, "mixed@example.com")
+	insertPasswordUser(t, "Mixed@Example.com")
+	res := signIn(t, app, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200", res.StatusCode)
+	}
+	if user := findUserByEmail(t, "Mixed@Example.com"); len(user.Identities) != 1 {
+		t.Fatalf("user = %+v, want the existing account with the identity linked", user)
+	}
+	n, err := UserDataCollection.CountDocuments(context.Background(), bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("%d users, want the existing one only", n)
+	}
+}
+
+// A password-less account confirms a password change by signing in with its
+// identity provider, recently, not just by holding a new session.
+func TestPasswordlessChangeNeedsRecentSSO(t *testing.T) {
+	app, _ := startSSO(t, "sso-only@example.com")
+	res := signIn(t, app, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("callback: status %d, want 200", res.StatusCode)
+	}
+	var sso struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&sso); err != nil {
+		t.Fatal(err)
+	}
+	user := findUserByEmail(t, "sso-only@example.com")
+	session := func(reauthenticatedAt *time.Time) string {
+		t.Helper()
+		token, _, err := createSession(context.Background(), user.ID, reauthenticatedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	longAgo := time.Now().Add(-2 * reauthWindow)
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"session without a provider sign-in", session(nil), http.StatusUnauthorized},
+		{"provider sign-in too long ago", session(&longAgo), http.StatusUnauthorized},
+		{"fresh provider sign-in", sso.Token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.NewReader(`{"new_password":"correct-horse","confirm_password":"correct-horse"}`)
+			req, _ := http.NewRequest(http.MethodPut, app.URL+"/me/password", body)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			req.Header.Set("Content-Type", "application/json")
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", res.StatusCode, tt.status)
+			}
+		})
+	}
+}
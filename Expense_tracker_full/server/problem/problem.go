@@ -0,0 +1,99 @@
+// Package problem renders every error response from the expense server as an
+// RFC 7807 application/problem+json document with a machine-readable code.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const ContentType = "application/problem+json"
+
+// Codes shared by handlers. Statuses without a more specific code fall back
+// to the generic code for that status in defaultCodes.
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeValidationFailed   = "validation_failed"
+	CodeUnauthenticated    = "unauthenticated"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeConflict           = "conflict"
+	CodeInternal           = "internal_error"
+	CodeUpstream           = "upstream_unavailable"
+	CodeUnavailable        = "service_unavailable"
+)
+
+var defaultCodes = map[int]string{
+	http.StatusBadRequest:          CodeInvalidRequest,
+	http.StatusUnauthorized:        CodeUnauthenticated,
+	http.StatusForbidden:           CodeForbidden,
+	http.StatusNotFound:            CodeNotFound,
+	http.StatusConflict:            CodeConflict,
+	http.StatusInternalServerError: CodeInternal,
+	http.StatusBadGateway:          CodeUpstream,
+	http.StatusServiceUnavailable:  CodeUnavailable,
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+func New(status int, detail string) *Problem {
+	code, ok := defaultCodes[status]
+	if !ok {
+		code = CodeInvalidRequest
+		if status >= 500 {
+			code = CodeInternal
+		}
+	}
+	return &Problem{Status: status, Title: http.StatusText(status), Detail: detail, Code: code}
+}
+
+func (p *Problem) WithCode(code string) *Problem {
+	p.Code = code
+	return p
+}
+
+func (p *Problem) WithField(field, message string) *Problem {
+	p.Errors = append(p.Errors, FieldError{Field: field, Message: message})
+	return p
+}
+
+func (p *Problem) Error() string {
+	return p.Code + ": " + p.Detail
+}
+
+// Render aborts the handler chain and writes p, filling in the request path
+// and the request ID assigned by the logging middleware.
+func Render(c *gin.Context, p *Problem) {
+	p.Type = "/problems/" + p.Code
+	if p.Instance == "" {
+		p.Instance = c.Request.URL.Path
+	}
+	p.RequestID = c.GetString("request_id")
+	body, err := json.Marshal(p)
+	if err != nil {
+		body = []byte(`{"type":"/problems/internal_error","title":"Internal Server Error","status":500,"code":"internal_error"}`)
+	}
+	c.Abort()
+	c.Data(p.Status, ContentType, body)
+}
+
+func Abort(c *gin.Context, status int, detail string) {
+	Render(c, New(status, detail))
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"gin-app/problem"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	ExpenseStandard = "standard"
+	ExpenseMileage  = "mileage"
+	ExpensePerDiem  = "per_diem"
+
+	maxRates   = 100
+	maxPerDiem = 366
+)
+
+// MileageDetails records what a mileage expense was calculated from. Rate is
+// copied from the workspace's table when the expense is saved, so later rate
+// changes don't alter past claims.
+type MileageDetails struct {
+	Vehicle  string  `json:"vehicle" bson:"vehicle"`
+	Distance float64 `json:"distance" bson:"distance"`
+	Rate     float64 `json:"rate" bson:"rate"`
+}
+
+type PerDiemDetails struct {
+	Location string  `json:"location" bson:"location"`
+	Days     float64 `json:"days" bson:"days"`
+	Rate     float64 `json:"rate" bson:"rate"`
+}
+
+// RateTables holds a workspace's mileage rate per unit of distance for each
+// vehicle type and its daily allowance for each per-diem location.
+type RateTables struct {
+	Mileage map[string]float64 `json:"mileage" bson:"mileage"`
+	PerDiem map[string]float64 `json:"per_diem" bson:"per_diem"`
+}
+
+func roundAmount(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// applyExpenseType validates a typed expense and derives its amount from the
+// workspace's current rates, or from previous's rate when it is an edit that
+// leaves what the amount was calculated from unchanged. Standard expenses keep
+// the amount they were given.
+func applyExpenseType(c *gin.Context, e, previous *Expense) bool {
+	var rates RateTables
+	if e.Type == ExpenseMileage || e.Type == ExpensePerDiem {
+		workspace, ok := findCurrentWorkspace(c)
+		if !ok {
+			return false
+		}
+		rates = workspace.Rates
+	}
+	if p := priceExpense(e, previous, rates); p != nil {
+		problem.Render(c, p)
+		return false
+	}
+	return true
+}
+
+// priceExpense sets e's amount from its mileage or per diem details. previous
+// is the saved expense when e is an edit of it, and nil otherwise; its rate is
+// kept unless the vehicle, distance, location or days changed.
+func priceExpense(e, previous *Expense, rates RateTables) *problem.Problem {
+	if e.Type == "" {
+		e.Type = ExpenseStandard
+	}
+	invalid := func(detail, field, msg string) *problem.Problem {
+		return problem.New(http.StatusBadRequest, detail).
+			WithCode(problem.CodeValidationFailed).
+			WithField(field, msg)
+	}
+	switch e.Type {
+	case ExpenseStandard:
+		e.Mileage, e.PerDiem = nil, nil
+	case ExpenseMileage:
+		e.PerDiem = nil
+		if e.Mileage == nil || e.Mileage.Distance <= 0 {
+			return invalid("Invalid mileage", "mileage.distance", "must be greater than 0")
+		}
+		var was *MileageDetails
+		if previous != nil && previous.Type == ExpenseMileage {
+			was = previous.Mileage
+		}
+		rate, ok := rates.Mileage[e.Mileage.Vehicle]
+		switch {
+		case was != nil && was.Vehicle == e.Mileage.Vehicle && was.Distance == e.Mileage.Distance:
+			rate = was.Rate
+		case !ok && was != nil && was.Vehicle == e.Mileage.Vehicle:
+			return invalid("Mileage rate removed", "mileage.vehicle", "the workspace no longer has a rate for this vehicle type, so the distance can't be changed")
+		case !ok:
+			return invalid("Unknown vehicle type", "mileage.vehicle", "must be a vehicle type in the workspace's mileage rates")
+		}
+		e.Mileage.Rate = rate
+		e.Amount = roundAmount(e.Mileage.Distance * rate)
+	case ExpensePerDiem:
+		e.Mileage = nil
+		if e.PerDiem == nil || e.PerDiem.Days <= 0 || e.PerDiem.Days > maxPerDiem {
+			return invalid("Invalid per diem", "per_diem.days", "must be greater than 0 and at most 366")
+		}
+		var was *PerDiemDetails
+		if previous != nil && previous.Type == ExpensePerDiem {
+			was = previous.PerDiem
+		}
+		rate, ok := rates.PerDiem[e.PerDiem.Location]
+		switch {
+		case was != nil && was.Location == e.PerDiem.Location && was.Days == e.PerDiem.Days:
+			rate = was.Rate
+		case !ok && was != nil && was.Location == e.PerDiem.Location:
+			return invalid("Per diem rate removed", "per_diem.location", "the workspace no longer has a rate for this location, so the days can't be changed")
+		case !ok:
+			return invalid("Unknown per diem location", "per_diem.location", "must be a location in the workspace's per diem rates")
+		}
+		e.PerDiem.Rate = rate
+		e.Amount = roundAmount(e.PerDiem.Days * rate)
+	default:
+		return invalid("Invalid expense type", "type", "must be standard, mileage or per_diem")
+	}
+	return nil
+}
+
+func getRates(c *gin.Context) {
+	workspace, ok := findCurrentWorkspace(c)
+	if !ok {
+		return
+	}
+	rates := workspace.Rates
+	if rates.Mileage == nil {
+		rates.Mileage = map[string]float64{}
+	}
+	if rates.PerDiem == nil {
+		rates.PerDiem = map[string]float64{}
+	}
+	c.JSON(http.StatusOK, rates)
+}
+
+// setRates replaces both rate tables. Expenses already saved keep the rate
+// they were calculated with.
+func setRates(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req RateTables
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	p := problem.New(http.StatusBadRequest, "Invalid rates").WithCode(problem.CodeValidationFailed)
+	rates := RateTables{
+		Mileage: validRates(p, "mileage", req.Mileage),
+		PerDiem: validRates(p, "per_diem", req.PerDiem),
+	}
+	if len(p.Errors) > 0 {
+		problem.Render(c, p)
+		return
+	}
+	res, err := WorkspaceCollection.UpdateOne(ctx, bson.M{"_id": currentWorkspaceID(c)}, bson.M{"$set": bson.M{
+		"rates":      rates,
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update rates")
+		return
+	}
+	if res.MatchedCount == 0 {
+		problem.Abort(c, http.StatusNotFound, "Workspace not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Rates updated"})
+}
+
+func validRates(p *problem.Problem, table string, req map[string]float64) map[string]float64 {
+	rates := make(map[string]float64, len(req))
+	if len(req) > maxRates {
+		p.WithField(table, "at most 100 rates are allowed")
+		return rates
+	}
+	for name, rate := range req {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.HasPrefix(name, "$") || strings.Contains(name, ".") {
+			p.WithField(table+"."+name, "name must not be empty, start with $ or contain a dot")
+			continue
+		}
+		if rate <= 0 {
+			p.WithField(table+"."+name, "rate must be greater than 0")
+			continue
+		}
+		rates[name] = rate
+	}
+	return rates
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"gin-app/problem"
+	"net/http"
+	"testing"
+)
+
+func TestPriceExpense(t *testing.T) {
+	rates := RateTables{
+		Mileage: map[string]float64{"car": 0.45, "bike": 0.2},
+		PerDiem: map[string]float64{"london": 90},
+	}
+	mileage := func(vehicle string, distance, rate float64) *Expense {
+		return &Expense{Type: ExpenseMileage, Mileage: &MileageDetails{Vehicle: vehicle, Distance: distance, Rate: rate}}
+	}
+	perDiem := func(location string, days, rate float64) *Expense {
+		return &Expense{Type: ExpensePerDiem, PerDiem: &PerDiemDetails{Location: location, Days: days, Rate: rate}}
+	}
+	tests := []struct {
+		name     string
+		e        *Expense
+		previous *Expense
+		amount   float64
+		rate     float64
+		field    string
+	}{
+		{name: "standard keeps its amount", e: &Expense{Amount: 12.5}, amount: 12.5},
+		{name: "mileage at the current rate", e: mileage("car", 100, 0), amount: 45, rate: 0.45},
+		{name: "mileage rounds to cents", e: mileage("bike", 3.33, 0), amount: 0.67, rate: 0.2},
+		{name: "mileage ignores the rate sent", e: mileage("car", 10, 5), amount: 4.5, rate: 0.45},
+		{name: "unknown vehicle", e: mileage("boat", 10, 0), field: "mileage.vehicle"},
+		{name: "no distance", e: mileage("car", 0, 0), field: "mileage.distance"},
+		{name: "no mileage details", e: &Expense{Type: ExpenseMileage}, field: "mileage.distance"},
+		{name: "unchanged edit keeps the stored rate", e: mileage("car", 100, 0), previous: mileage("car", 100, 0.3), amount: 30, rate: 0.3},
+		{name: "new distance takes the current rate", e: mileage("car", 50, 0), previous: mileage("car", 100, 0.3), amount: 22.5, rate: 0.45},
+		{name: "new vehicle takes the current rate", e: mileage("bike", 100, 0), previous: mileage("car", 100, 0.3), amount: 20, rate: 0.2},
+		{name: "unchanged edit after the rate was removed", e: mileage("van", 10, 0), previous: mileage("van", 10, 0.6), amount: 6, rate: 0.6},
+		{name: "new distance after the rate was removed", e: mileage("van", 20, 0), previous: mileage("van", 10, 0.6), field: "mileage.vehicle"},
+		{name: "per diem at the current rate", e: perDiem("london", 2, 0), amount: 180, rate: 90},
+		{name: "too many days", e: perDiem("london", 367, 0), field: "per_diem.days"},
+		{name: "unknown location", e: perDiem("paris", 1, 0), field: "per_diem.location"},
+		{name: "unchanged per diem keeps the stored rate", e: perDiem("london", 2, 0), previous: perDiem("london", 2, 80), amount: 160, rate: 80},
+		{name: "new days take the current rate", e: perDiem("london", 3, 0), previous: perDiem("london", 2, 80), amount: 270, rate: 90},
+		{name: "a mileage expense turned per diem", e: perDiem("london", 1, 0), previous: mileage("car", 1, 0.3), amount: 90, rate: 90},
+		{name: "unknown type", e: &Expense{Type: "hourly"}, field: "type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := priceExpense(tt.e, tt.previous, rates)
+			if tt.field != "" {
+				if p == nil {
+					t.Fatalf("want an error on %s, got amount %v", tt.field, tt.e.Amount)
+				}
+				if len(p.Errors) != 1 || p.Errors[0].Field != tt.field {
+					t.Fatalf("errors = %+v, want one on %s", p.Errors, tt.field)
+				}
+				return
+			}
+			if p != nil {
+				t.Fatalf("unexpected problem: %+v", p)
+			}
+			if tt.e.Amount != tt.amount {
+				t.Errorf("amount = %v, want %v", tt.e.Amount, tt.amount)
+			}
+			var rate float64
+			switch {
+			case tt.e.Mileage != nil:
+				rate = tt.e.Mileage.Rate
+			case tt.e.PerDiem != nil:
+				rate = tt.e.PerDiem.Rate
+			}
+			if rate != tt.rate {
+				t.Errorf("rate = %v, want %v", rate, tt.rate)
+			}
+		})
+	}
+}
+
+func TestPriceExpenseClearsOtherDetails(t *testing.T) {
+	e := &Expense{
+		Amount:  5,
+		Mileage: &MileageDetails{Vehicle: "car", Distance: 1},
+		PerDiem: &PerDiemDetails{Location: "london", Days: 1},
+	}
+	if p := priceExpense(e, nil, RateTables{}); p != nil {
+		t.Fatal(p)
+	}
+	if e.Type != ExpenseStandard || e.Mileage != nil || e.PerDiem != nil {
+		t.Errorf("got type %q, mileage %v, per diem %v", e.Type, e.Mileage, e.PerDiem)
+	}
+}
+
+func TestValidRates(t *testing.T) {
+	tooMany := map[string]float64{}
+	for i := range maxRates + 1 {
+		tooMany[string(rune('a'+i%26))+string(rune('a'+i/26))] = 1
+	}
+	tests := []struct {
+		name   string
+		req    map[string]float64
+		rates  map[string]float64
+		fields []string
+	}{
+		{name: "empty", req: nil, rates: map[string]float64{}},
+		{name: "valid", req: map[string]float64{"car": 0.45, " bike ": 0.2}, rates: map[string]float64{"car": 0.45, "bike": 0.2}},
+		{name: "blank name", req: map[string]float64{" ": 1}, rates: map[string]float64{}, fields: []string{"mileage."}},
+		{name: "operator name", req: map[string]float64{"$set": 1}, rates: map[string]float64{}, fields: []string{"mileage.$set"}},
+		{name: "dotted name", req: map[string]float64{"a.b": 1, "car": 1}, rates: map[string]float64{"car": 1}, fields: []string{"mileage.a.b"}},
+		{name: "zero rate", req: map[string]float64{"car": 0}, rates: map[string]float64{}, fields: []string{"mileage.car"}},
+		{name: "negative rate", req: map[string]float64{"car": -1}, rates: map[string]float64{}, fields: []string{"mileage.car"}},
+		{name: "too many", req: tooMany, rates: map[string]float64{}, fields: []string{"mileage"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := problem.New(http.StatusBadRequest, "Invalid rates")
+			rates := validRates(p, "mileage", tt.req)
+			if len(rates) != len(tt.rates) {
+				t.Errorf("rates = %v, want %v", rates, tt.rates)
+			}
+			for name, rate := range tt.rates {
+				if rates[name] != rate {
+					t.Errorf("rates[%q] = %v, want %v", name, rates[name], rate)
+				}
+			}
+			if len(p.Errors) != len(tt.fields) {
+				t.Fatalf("errors = %+v, want %v", p.Errors, tt.fields)
+			}
+			for i, field := range tt.fields {
+				if p.Errors[i].Field != field {
+					t.Errorf("error %d on %q, want %q", i, p.Errors[i].Field, field)
+				}
+			}
+		})
+	}
+}
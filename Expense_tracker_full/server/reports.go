@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"gin-app/problem"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const monthLayout = "2006-01"
+
+type MonthlyCashFlow struct {
+	Month    string  `json:"month"`
+	Income   float64 `json:"income"`
+	Expenses float64 `json:"expenses"`
+	Net      float64 `json:"net"`
+}
+
+// userLocation returns the caller's profile timezone so month boundaries in
+// reports match what the user sees on their calendar.
+func userLocation(ctx context.Context, userID primitive.ObjectID) *time.Location {
+	var profile struct {
+		Timezone string `bson:"timezone"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"timezone": 1})
+	if err := UserDataCollection.FindOne(ctx, bson.M{"_id": userID}, opts).Decode(&profile); err != nil {
+		return time.UTC
+	}
+	return locationFor(profile.Timezone)
+}
+
+func locationFor(timezone string) *time.Location {
+	if loc, err := time.LoadLocation(timezone); err == nil && timezone != "" {
+		return loc
+	}
+	return time.UTC
+}
+
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// monthRange parses from/to query parameters (YYYY-MM, inclusive) in loc,
+// defaulting to the twelve months ending with the current one.
+func monthRange(c *gin.Context, loc *time.Location) (time.Time, time.Time, bool) {
+	end := startOfMonth(time.Now().In(loc)).AddDate(0, 1, 0)
+	start := end.AddDate(0, -12, 0)
+	if v := c.Query("from"); v != "" {
+		t, err := time.ParseInLocation(monthLayout, v, loc)
+		if err != nil {
+			problem.Render(c, problem.New(http.StatusBadRequest, "Invalid month").
+				WithCode(problem.CodeValidationFailed).
+				WithField("from", "must be formatted as YYYY-MM"))
+			return start, end, false
+		}
+		start = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.ParseInLocation(monthLayout, v, loc)
+		if err != nil {
+			problem.Render(c, problem.New(http.StatusBadRequest, "Invalid month").
+				WithCode(problem.CodeValidationFailed).
+				WithField("to", "must be formatted as YYYY-MM"))
+			return start, end, false
+		}
+		end = t.AddDate(0, 1, 0)
+	}
+	if !start.Before(end) {
+		problem.Abort(c, http.StatusBadRequest, "from must not be after to")
+		return start, end, false
+	}
+	return start, end, true
+}
+
+// monthlyTotals sums amount per calendar month in loc for documents matching
+// filter, which must already restrict the owner.
+func monthlyTotals(ctx context.Context, coll *mongo.Collection, filter bson.M, loc *time.Location, start, end time.Time) (map[string]float64, error) {
+	match := bson.M{"$and": bson.A{filter, bson.M{"date": bson.M{"$gte": start, "$lt": end}}}}
+	cur, err := coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": "$date", "timezone": loc.String()}},
+			"total": bson.M{"$sum": "$amount"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Month string  `bson:"_id"`
+		Total float64 `bson:"total"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	totals := make(map[string]float64, len(rows))
+	for _, row := range rows {
+		totals[row.Month] = row.Total
+	}
+	return totals, nil
+}
+
+// cashFlow nets the user's monthly income against the workspace expenses
+// matching f, so a tag or saved search narrows the outflow side of the report.
+// Income belongs to the user rather than a workspace, so a zero userID
+// leaves it out.
+func cashFlow(ctx context.Context, userID, workspaceID primitive.ObjectID, f ExpenseFilter, loc *time.Location, start, end time.Time) ([]MonthlyCashFlow, error) {
+	income := map[string]float64{}
+	if !userID.IsZero() {
+		var err error
+		income, err = monthlyTotals(ctx, IncomeCollection, bson.M{"user_id": userID}, loc, start, end)
+		if err != nil {
+			return nil, err
+		}
+	}
+	expenses, err := monthlyTotals(ctx, collection, f.Match(workspaceID), loc, start, end)
+	if err != nil {
+		return nil, err
+	}
+	months := []MonthlyCashFlow{}
+	for m := start; m.Before(end); m = m.AddDate(0, 1, 0) {
+		key := m.Format(monthLayout)
+		months = append(months, MonthlyCashFlow{
+			Month:    key,
+			Income:   income[key],
+			Expenses: expenses[key],
+			Net:      income[key] - expenses[key],
+		})
+	}
+	return months, nil
+}
+
+// incomeOwner is whose income the current workspace's cash flow counts: the
+// caller's in their personal workspace and nobody's in a shared one, where
+// it would be set against other members' spending.
+func incomeOwner(c *gin.Context) primitive.ObjectID {
+	if currentWorkspaceID(c) != personalWorkspaceID(c) {
+		return primitive.NilObjectID
+	}
+	return currentUserID(c)
+}
+
+func getCashFlow(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := currentUserID(c)
+	loc := userLocation(ctx, userID)
+	start, end, ok := monthRange(c, loc)
+	if !ok {
+		return
+	}
+	f, ok := expenseFilterFromQuery(c)
+	if !ok {
+		return
+	}
+	months, err := cashFlow(ctx, incomeOwner(c), currentWorkspaceID(c), f, loc, start, end)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to compute cash flow")
+		return
+	}
+	var total MonthlyCashFlow
+	for _, m := range months {
+		total.Income += m.Income
+		total.Expenses += m.Expenses
+		total.Net += m.Net
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"timezone": loc.String(),
+		"months":   months,
+		"totals":   gin.H{"income": total.Income, "expenses": total.Expenses, "net": total.Net},
+	})
+}
+
+// categoryStats totals the expenses matching filter per category between
+// start and end, largest first. filter must already restrict the workspace.
+func categoryStats(ctx context.Context, filter bson.M, start, end time.Time) ([]CategoryStat, error) {
+	match := bson.M{"$and": bson.A{filter, bson.M{"date": bson.M{"$gte": start, "$lt": end}}}}
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$category",
+			"count": bson.M{"$sum": 1},
+			"total": bson.M{"$sum": "$amount"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "total", Value: -1}, {Key: "_id", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats := []CategoryStat{}
+	if err := cur.All(ctx, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gin-app/config"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// runRetentionJob applies the retention policy to accounts whose grace period
+// has run out. Each step is idempotent, so an interrupted run is simply
+// finished by the next one, on this instance or another.
+func runRetentionJob(ctx context.Context) {
+	ticker := time.NewTicker(cfg.Retention.CheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := applyRetention(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			slog.Error("retention run failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func applyRetention(ctx context.Context, now time.Time) error {
+	cur, err := UserDataCollection.Find(ctx, bson.M{
+		"deleted_at":    bson.M{"$lte": now.Add(-cfg.Retention.GracePeriod)},
+		"anonymised_at": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var user SignupUser
+		if err := cur.Decode(&user); err != nil {
+			slog.Warn("skipping malformed user", "error", err)
+			continue
+		}
+		if cfg.Retention.Policy == config.RetentionAnonymise {
+			err = anonymiseUser(ctx, user, now)
+		} else {
+			err = purgeUser(ctx, user)
+		}
+		if err != nil {
+			slog.Error("apply retention policy", "user_id", user.ID.Hex(), "policy", cfg.Retention.Policy, "error", err)
+			continue
+		}
+		slog.Info("applied retention policy", "user_id", user.ID.Hex(), "policy", cfg.Retention.Policy)
+	}
+	return cur.Err()
+}
+
+// removePersonalData deletes what both policies discard: credentials, saved
+// searches, goals, statements and invitations addressed to the user.
+func removePersonalData(ctx context.Context, user SignupUser) error {
+	byUser := bson.M{"user_id": user.ID}
+	if _, err := SessionCollection.DeleteMany(ctx, byUser); err != nil {
+		return fmt.Errorf("delete sessions: %w", err)
+	}
+	if _, err := APIKeyCollection.DeleteMany(ctx, byUser); err != nil {
+		return fmt.Errorf("delete API keys: %w", err)
+	}
+	if _, err := SavedSearchCollection.DeleteMany(ctx, byUser); err != nil {
+		return fmt.Errorf("delete saved searches: %w", err)
+	}
+	if _, err := GoalCollection.DeleteMany(ctx, byUser); err != nil {
+		return fmt.Errorf("delete savings goals: %w", err)
+	}
+	if _, err := StatementCollection.DeleteMany(ctx, byUser); err != nil {
+		return fmt.Errorf("delete statements: %w", err)
+	}
+	if _, err := InvitationCollection.DeleteMany(ctx, bson.M{"email": user.Email}); err != nil {
+		return fmt.Errorf("delete invitations: %w", err)
+	}
+	if err := os.RemoveAll(filepath.Join(cfg.Statements.Dir, user.ID.Hex())); err != nil {
+		return fmt.Errorf("delete statement files: %w", err)
+	}
+	return nil
+}
+
+// purgeUser deletes the account and everything in it, including the
+// workspaces the user owns.
+func purgeUser(ctx context.Context, user SignupUser) error {
+	if err := removePersonalData(ctx, user); err != nil {
+		return err
+	}
+	if err := leaveWorkspaces(ctx, user.ID); err != nil {
+		return fmt.Errorf("delete workspaces: %w", err)
+	}
+	if _, err := IncomeCollection.DeleteMany(ctx, bson.M{"user_id": user.ID}); err != nil {
+		return fmt.Errorf("delete income: %w", err)
+	}
+	_, err := UserDataCollection.DeleteOne(ctx, bson.M{"_id": user.ID})
+	return err
+}
+
+// anonymiseUser keeps the user's expenses and income for reporting but strips
+// everything that identifies them or lets anyone sign in as them. The email
+// is replaced so the address can be used for a new account.
+func anonymiseUser(ctx context.Context, user SignupUser, now time.Time) error {
+	if err := removePersonalData(ctx, user); err != nil {
+		return err
+	}
+	_, err := WorkspaceCollection.UpdateMany(ctx,
+		bson.M{"members.user_id": user.ID, "owner_id": bson.M{"$ne": user.ID}},
+		bson.M{"$pull": bson.M{"members": bson.M{"user_id": user.ID}}},
+	)
+	if err != nil {
+		return fmt.Errorf("leave workspaces: %w", err)
+	}
+	_, err = UserDataCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{
+			"email":         anonymisedEmail(user.ID),
+			"display_name":  "",
+			"password":      "",
+			"anonymised_at": now,
+			"updated_at":    now,
+		},
+		"$unset": bson.M{
+			"identities":          "",
+			"totp_enabled":        "",
+			"totp_secret":         "",
+			"totp_pending_secret": "",
+			"totp_last_step":      "",
+			"recovery_codes":      "",
+		},
+	})
+	return err
+}
+
+func anonymisedEmail(id primitive.ObjectID) string {
+	return "deleted-" + id.Hex() + "@anonymised.invalid"
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"gin-app/encryption"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// runRotationJob keeps expense descriptions sealed with the active key: it
+// encrypts ones stored before encryption was enabled and rewraps ones sealed
+// with a retired key. Only the data keys change, so rewrapping never needs the
+// plaintext. Ones sealed before descriptions were bound to their expense are
+// sealed again, bound.
+func runRotationJob(ctx context.Context, keyring *encryption.Keyring) {
+	ticker := time.NewTicker(cfg.Encryption.RotationInterval)
+	defer ticker.Stop()
+	for {
+		if err := rotateDescriptions(ctx, keyring); err != nil && ctx.Err() == nil {
+			slog.Error("key rotation run failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func rotateDescriptions(ctx context.Context, keyring *encryption.Keyring) error {
+	active := keyring.ActiveKey()
+	cur, err := collection.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"description": bson.M{"$type": "string", "$ne": ""}},
+		bson.M{"description.k": bson.M{"$exists": true, "$ne": active}},
+		bson.M{"description.k": bson.M{"$exists": true}, "description.b": bson.M{"$exists": false}},
+	}})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	var sealed, rewrapped, bound int
+	for cur.Next(ctx) {
+		var doc struct {
+			ID          primitive.ObjectID `bson:"_id"`
+			Description bson.RawValue      `bson:"description"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			slog.Warn("skipping malformed expense", "error", err)
+			continue
+		}
+		var value any
+		switch doc.Description.Type {
+		case bsontype.String:
+			value = encryption.Bind(encryption.String(doc.Description.StringValue()), doc.ID[:])
+			sealed++
+		case bsontype.EmbeddedDocument:
+			var e encryption.Envelope
+			if err := doc.Description.Unmarshal(&e); err != nil {
+				slog.Warn("skipping malformed envelope", "expense_id", doc.ID.Hex(), "error", err)
+				continue
+			}
+			if !e.Bound {
+				plaintext, err := encryption.Open(doc.Description, nil)
+				if err != nil {
+					slog.Error("open unbound description", "expense_id", doc.ID.Hex(), "key", e.KeyID, "error", err)
+					continue
+				}
+				value = encryption.Bind(plaintext, doc.ID[:])
+				bound++
+				break
+			}
+			if e, err = keyring.Rewrap(e); err != nil {
+				slog.Error("rewrap description", "expense_id", doc.ID.Hex(), "key", e.KeyID, "error", err)
+				continue
+			}
+			value = e
+			rewrapped++
+		default:
+			continue
+		}
+		// Matching on the old value leaves alone an expense edited since it was
+		// read; the edit has already sealed it with the active key.
+		_, err := collection.UpdateOne(ctx,
+			bson.M{"_id": doc.ID, "description": doc.Description},
+			bson.M{"$set": bson.M{"description": value}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if sealed > 0 || rewrapped > 0 || bound > 0 {
+		slog.Info("rotated expense descriptions", "key", active, "encrypted", sealed, "rewrapped", rewrapped, "bound", bound)
+	}
+	return cur.Err()
+}
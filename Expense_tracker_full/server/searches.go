@@ -0,0 +1,357 @@
+package main
+
+import (
+	"gin-app/encryption"
+	"gin-app/problem"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	TagMatchAny = "any"
+	TagMatchAll = "all"
+
+	maxTags      = 20
+	maxTagLength = 40
+)
+
+// ExpenseFilter is the filter definition shared by the list, export and
+// report endpoints and stored verbatim in saved searches.
+type ExpenseFilter struct {
+	Category  string     `json:"category,omitempty" bson:"category,omitempty"`
+	Tags      []string   `json:"tags,omitempty" bson:"tags,omitempty"`
+	TagMatch  string     `json:"tag_match,omitempty" bson:"tag_match,omitempty"`
+	Text      string     `json:"text,omitempty" bson:"text,omitempty"`
+	From      *time.Time `json:"from,omitempty" bson:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty" bson:"to,omitempty"`
+	MinAmount *float64   `json:"min_amount,omitempty" bson:"min_amount,omitempty"`
+	MaxAmount *float64   `json:"max_amount,omitempty" bson:"max_amount,omitempty"`
+}
+
+type SavedSearch struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"-" bson:"user_id"`
+	Name      string             `json:"name" bson:"name"`
+	Filter    ExpenseFilter      `json:"filter" bson:"filter"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+type TagStat struct {
+	Tag   string  `bson:"_id" json:"tag"`
+	Count int64   `bson:"count" json:"count"`
+	Total float64 `bson:"total" json:"total"`
+}
+
+var SavedSearchCollection *mongo.Collection
+
+// normaliseTags lower-cases and trims tags and drops blanks and duplicates,
+// so "Travel" and "travel " are the same tag.
+func normaliseTags(tags []string) []string {
+	out := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+func validTags(c *gin.Context, tags []string) bool {
+	if len(tags) > maxTags {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Too many tags").
+			WithCode(problem.CodeValidationFailed).
+			WithField("tags", "at most 20 tags are allowed"))
+		return false
+	}
+	for _, tag := range tags {
+		if len(tag) > maxTagLength {
+			problem.Render(c, problem.New(http.StatusBadRequest, "Tag too long").
+				WithCode(problem.CodeValidationFailed).
+				WithField("tags", "tags must be at most 40 characters"))
+			return false
+		}
+	}
+	return true
+}
+
+func (f ExpenseFilter) validate(c *gin.Context) bool {
+	p := problem.New(http.StatusBadRequest, "Invalid filter").WithCode(problem.CodeValidationFailed)
+	if f.TagMatch != "" && f.TagMatch != TagMatchAny && f.TagMatch != TagMatchAll {
+		p = p.WithField("tag_match", "must be any or all")
+	}
+	if f.From != nil && f.To != nil && f.From.After(*f.To) {
+		p = p.WithField("from", "must not be after to")
+	}
+	if f.MinAmount != nil && f.MaxAmount != nil && *f.MinAmount > *f.MaxAmount {
+		p = p.WithField("min_amount", "must not be greater than max_amount")
+	}
+	if len(p.Errors) > 0 {
+		problem.Render(c, p)
+		return false
+	}
+	return true
+}
+
+// Match builds the Mongo query for the workspace's expenses matching f.
+func (f ExpenseFilter) Match(workspaceID primitive.ObjectID) bson.M {
+	match := bson.M{"workspace_id": workspaceID}
+	if f.Category != "" {
+		match["category"] = f.Category
+	}
+	if tags := normaliseTags(f.Tags); len(tags) > 0 {
+		if f.TagMatch == TagMatchAll {
+			match["tags"] = bson.M{"$all": tags}
+		} else {
+			match["tags"] = bson.M{"$in": tags}
+		}
+	}
+	// Encrypted descriptions are subdocuments the regex can't match, so with
+	// encryption enabled only titles and not-yet-sealed descriptions match.
+	if f.Text != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(f.Text), Options: "i"}
+		match["$or"] = bson.A{bson.M{"title": pattern}, bson.M{"description": pattern}}
+	}
+	if f.From != nil || f.To != nil {
+		date := bson.M{}
+		if f.From != nil {
+			date["$gte"] = *f.From
+		}
+		if f.To != nil {
+			date["$lte"] = *f.To
+		}
+		match["date"] = date
+	}
+	if f.MinAmount != nil || f.MaxAmount != nil {
+		amount := bson.M{}
+		if f.MinAmount != nil {
+			amount["$gte"] = *f.MinAmount
+		}
+		if f.MaxAmount != nil {
+			amount["$lte"] = *f.MaxAmount
+		}
+		match["amount"] = amount
+	}
+	return match
+}
+
+// expenseFilterFromQuery reads ?search=<saved search id> as the base filter
+// and lets ?category, ?tags and ?tag_match narrow or override it.
+func expenseFilterFromQuery(c *gin.Context) (ExpenseFilter, bool) {
+	var f ExpenseFilter
+	if id := c.Query("search"); id != "" {
+		search, ok := findSavedSearch(c, id)
+		if !ok {
+			return f, false
+		}
+		f = search.Filter
+	}
+	if category := c.Query("category"); category != "" {
+		f.Category = category
+	}
+	var tags []string
+	for _, v := range c.QueryArray("tags") {
+		tags = append(tags, strings.Split(v, ",")...)
+	}
+	if len(tags) > 0 {
+		f.Tags = normaliseTags(tags)
+		if !validTags(c, f.Tags) {
+			return f, false
+		}
+	}
+	if match := c.Query("tag_match"); match != "" {
+		f.TagMatch = match
+	}
+	if !f.validate(c) {
+		return f, false
+	}
+	noteTextScope(c, f)
+	return f, true
+}
+
+// noteTextScope tells the client when a text search skipped descriptions,
+// which are sealed while field-level encryption is enabled (see Match).
+func noteTextScope(c *gin.Context, f ExpenseFilter) {
+	if f.Text != "" && encryption.Current() != nil {
+		c.Header("X-Text-Search-Scope", "title")
+	}
+}
+
+func findSavedSearch(c *gin.Context, id string) (SavedSearch, bool) {
+	var search SavedSearch
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return search, false
+	}
+	err = SavedSearchCollection.FindOne(c.Request.Context(), bson.M{"_id": objID, "user_id": currentUserID(c)}).Decode(&search)
+	if err == mongo.ErrNoDocuments {
+		problem.Abort(c, http.StatusNotFound, "Saved search not found")
+		return search, false
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch saved search")
+		return search, false
+	}
+	return search, true
+}
+
+func bindSavedSearch(c *gin.Context) (SavedSearch, bool) {
+	var search SavedSearch
+	if err := c.ShouldBindJSON(&search); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return search, false
+	}
+	if strings.TrimSpace(search.Name) == "" {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Name is required").
+			WithCode(problem.CodeValidationFailed).
+			WithField("name", "is required"))
+		return search, false
+	}
+	search.Filter.Tags = normaliseTags(search.Filter.Tags)
+	if len(search.Filter.Tags) == 0 {
+		search.Filter.Tags = nil
+	}
+	if !validTags(c, search.Filter.Tags) || !search.Filter.validate(c) {
+		return search, false
+	}
+	return search, true
+}
+
+func createSavedSearch(c *gin.Context) {
+	ctx := c.Request.Context()
+	search, ok := bindSavedSearch(c)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	search.ID = primitive.NilObjectID
+	search.UserID = currentUserID(c)
+	search.CreatedAt = now
+	search.UpdatedAt = now
+	res, err := SavedSearchCollection.InsertOne(ctx, search)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to create saved search")
+		return
+	}
+	search.ID = res.InsertedID.(primitive.ObjectID)
+	c.JSON(http.StatusCreated, search)
+}
+
+func getSavedSearches(c *gin.Context) {
+	ctx := c.Request.Context()
+	cur, err := SavedSearchCollection.Find(ctx, bson.M{"user_id": currentUserID(c)}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch saved searches")
+		return
+	}
+	searches := []SavedSearch{}
+	if err := cur.All(ctx, &searches); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch saved searches")
+		return
+	}
+	c.JSON(http.StatusOK, searches)
+}
+
+func getSavedSearchByID(c *gin.Context) {
+	search, ok := findSavedSearch(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, search)
+}
+
+func updateSavedSearch(c *gin.Context) {
+	ctx := c.Request.Context()
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	search, ok := bindSavedSearch(c)
+	if !ok {
+		return
+	}
+	res, err := SavedSearchCollection.UpdateOne(ctx, bson.M{"_id": objID, "user_id": currentUserID(c)}, bson.M{"$set": bson.M{
+		"name":       search.Name,
+		"filter":     search.Filter,
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update saved search")
+		return
+	}
+	if res.MatchedCount == 0 {
+		problem.Abort(c, http.StatusNotFound, "Saved search not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Saved search updated"})
+}
+
+func deleteSavedSearch(c *gin.Context) {
+	ctx := c.Request.Context()
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	res, err := SavedSearchCollection.DeleteOne(ctx, bson.M{"_id": objID, "user_id": currentUserID(c)})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to delete saved search")
+		return
+	}
+	if res.DeletedCount == 0 {
+		problem.Abort(c, http.StatusNotFound, "Saved search not found")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// runSavedSearch returns the expenses matching a saved search, the same
+// shape as GET /expense.
+func runSavedSearch(c *gin.Context) {
+	search, ok := findSavedSearch(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	noteTextScope(c, search.Filter)
+	listExpenses(c, search.Filter)
+}
+
+func getTags(c *gin.Context) {
+	ctx := c.Request.Context()
+	f, ok := expenseFilterFromQuery(c)
+	if !ok {
+		return
+	}
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: f.Match(currentWorkspaceID(c))}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$tags",
+			"count": bson.M{"$sum": 1},
+			"total": bson.M{"$sum": "$amount"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "total", Value: -1}, {Key: "_id", Value: 1}}}},
+	})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch tags")
+		return
+	}
+	tags := []TagStat{}
+	if err := cur.All(ctx, &tags); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch tags")
+		return
+	}
+	c.JSON(http.StatusOK, tags)
+}
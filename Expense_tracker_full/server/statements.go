@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gin-app/config"
+	"gin-app/problem"
+	"gin-app/statements"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	statementPending   = "sending"
+	statementDelivered = "delivered"
+	statementFailed    = "failed"
+
+	maxStatementAttempts = 5
+	statementLease       = 10 * time.Minute
+)
+
+type StatementRun struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	UserID      primitive.ObjectID `bson:"user_id" json:"-"`
+	Period      string             `bson:"period" json:"period"`
+	Status      string             `bson:"status" json:"status"`
+	Attempts    int                `bson:"attempts" json:"attempts"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+	LockedUntil *time.Time         `bson:"locked_until,omitempty" json:"-"`
+	DeliveredAt *time.Time         `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+var StatementCollection *mongo.Collection
+
+func newDeliverer(c config.StatementsConfig) statements.Deliverer {
+	return statements.FileDeliverer{Dir: c.Dir}
+}
+
+// runStatementScheduler checks every interval for users whose previous month
+// has closed in their own timezone and sends that month's statement. Run
+// records make it safe to run on several instances and to restart mid-way.
+func runStatementScheduler(ctx context.Context, deliverer statements.Deliverer) {
+	ticker := time.NewTicker(cfg.Statements.CheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := sendDueStatements(ctx, deliverer, time.Now()); err != nil && ctx.Err() == nil {
+			slog.Error("statement run failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sendDueStatements(ctx context.Context, deliverer statements.Deliverer, now time.Time) error {
+	cur, err := UserDataCollection.Find(ctx, bson.M{"disabled": bson.M{"$ne": true}})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var user SignupUser
+		if err := cur.Decode(&user); err != nil {
+			slog.Warn("skipping malformed user", "error", err)
+			continue
+		}
+		loc := locationFor(user.Timezone)
+		period := startOfMonth(now.In(loc)).AddDate(0, -1, 0)
+		if user.CreatedAt.After(period.AddDate(0, 1, 0)) {
+			continue
+		}
+		claimed, err := claimStatement(ctx, user.ID, period.Format(monthLayout), now)
+		if err != nil {
+			slog.Error("claim statement", "user_id", user.ID.Hex(), "error", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		err = deliverStatement(ctx, deliverer, user, period, now)
+		finishStatement(ctx, user.ID, period.Format(monthLayout), err)
+		if err != nil {
+			slog.Error("deliver statement", "user_id", user.ID.Hex(), "period", period.Format(monthLayout), "error", err)
+		}
+	}
+	return cur.Err()
+}
+
+// claimStatement takes a lease on the user's run for period. The upsert
+// collides with the unique (user_id, period) index when the run is already
+// delivered, leased by another instance or out of attempts.
+func claimStatement(ctx context.Context, userID primitive.ObjectID, period string, now time.Time) (bool, error) {
+	_, err := StatementCollection.UpdateOne(ctx, bson.M{
+		"user_id":  userID,
+		"period":   period,
+		"status":   bson.M{"$ne": statementDelivered},
+		"attempts": bson.M{"$lt": maxStatementAttempts},
+		"$or": bson.A{
+			bson.M{"locked_until": bson.M{"$exists": false}},
+			bson.M{"locked_until": bson.M{"$lt": now}},
+		},
+	}, bson.M{
+		"$set":         bson.M{"status": statementPending, "locked_until": now.Add(statementLease), "updated_at": now},
+		"$inc":         bson.M{"attempts": 1},
+		"$setOnInsert": bson.M{"created_at": now},
+	}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func finishStatement(ctx context.Context, userID primitive.ObjectID, period string, deliveryErr error) {
+	now := time.Now()
+	update := bson.M{
+		"$set":   bson.M{"status": statementDelivered, "delivered_at": now, "updated_at": now},
+		"$unset": bson.M{"locked_until": "", "error": ""},
+	}
+	if deliveryErr != nil {
+		update = bson.M{
+			"$set":   bson.M{"status": statementFailed, "error": deliveryErr.Error(), "updated_at": now},
+			"$unset": bson.M{"locked_until": ""},
+		}
+	}
+	// The request context may already be cancelled on shutdown; recording
+	// the outcome still matters so the run isn't retried needlessly.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), pingTimeout)
+	defer cancel()
+	if _, err := StatementCollection.UpdateOne(ctx, bson.M{"user_id": userID, "period": period}, update); err != nil {
+		slog.Error("record statement run", "user_id", userID.Hex(), "period", period, "error", err)
+	}
+}
+
+// deliverStatement sends the statement for the user's personal workspace.
+func deliverStatement(ctx context.Context, deliverer statements.Deliverer, user SignupUser, period, now time.Time) error {
+	var workspace Workspace
+	if err := WorkspaceCollection.FindOne(ctx, bson.M{"_id": user.DefaultWorkspaceID}).Decode(&workspace); err != nil {
+		return fmt.Errorf("personal workspace: %w", err)
+	}
+	s, err := buildStatement(ctx, user, workspace, period, now)
+	if err != nil {
+		return err
+	}
+	html, err := statements.RenderHTML(s)
+	if err != nil {
+		return err
+	}
+	pdf, err := statements.RenderPDF(s)
+	if err != nil {
+		return err
+	}
+	return deliverer.Deliver(ctx, statements.Delivery{Statement: s, HTML: html, PDF: pdf})
+}
+
+// buildStatement gathers the workspace's figures, alongside the user's own
+// income, for the calendar month starting at period, which must already be
+// in the user's timezone.
+func buildStatement(ctx context.Context, user SignupUser, workspace Workspace, period, now time.Time) (statements.Statement, error) {
+	end := period.AddDate(0, 1, 0)
+	s := statements.Statement{
+		UserID:      user.ID,
+		Email:       user.Email,
+		DisplayName: user.DisplayName,
+		Currency:    user.BaseCurrency,
+		Timezone:    period.Location().String(),
+		Period:      period,
+		GeneratedAt: now.In(period.Location()),
+	}
+	if s.Currency == "" {
+		s.Currency = defaultCurrency
+	}
+	if !workspace.Personal {
+		s.Workspace = workspace.Name
+	}
+	stats, err := categoryStats(ctx, bson.M{"workspace_id": workspace.ID}, period, end)
+	if err != nil {
+		return s, fmt.Errorf("category totals: %w", err)
+	}
+	seen := make(map[string]bool, len(stats))
+	for _, stat := range stats {
+		line := statements.CategoryLine{Category: stat.Category, Count: stat.Count, Total: stat.Total}
+		if limit, ok := workspace.Budgets[stat.Category]; ok {
+			line.Budget = &limit
+			line.Status = statements.BudgetStatus(stat.Total, limit)
+		}
+		s.Categories = append(s.Categories, line)
+		s.Total += stat.Total
+		seen[stat.Category] = true
+	}
+	var unspent []string
+	for category := range workspace.Budgets {
+		if !seen[category] {
+			unspent = append(unspent, category)
+		}
+	}
+	sort.Strings(unspent)
+	for _, category := range unspent {
+		limit := workspace.Budgets[category]
+		s.Categories = append(s.Categories, statements.CategoryLine{Category: category, Budget: &limit, Status: statements.BudgetOK})
+	}
+
+	s.Income, err = sumAmount(ctx, IncomeCollection, bson.M{"user_id": user.ID, "date": bson.M{"$gte": period, "$lt": end}})
+	if err != nil {
+		return s, fmt.Errorf("income total: %w", err)
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "amount", Value: -1}, {Key: "date", Value: 1}}).SetLimit(int64(cfg.Statements.TopExpenses))
+	cur, err := collection.Find(ctx, bson.M{"workspace_id": workspace.ID, "date": bson.M{"$gte": period, "$lt": end}}, opts)
+	if err != nil {
+		return s, fmt.Errorf("top expenses: %w", err)
+	}
+	var top []Expense
+	if err := cur.All(ctx, &top); err != nil {
+		return s, fmt.Errorf("top expenses: %w", err)
+	}
+	for _, e := range top {
+		s.TopExpenses = append(s.TopExpenses, statements.ExpenseLine{
+			Date:     e.Date.In(period.Location()),
+			Title:    e.Title,
+			Category: e.Category,
+			Amount:   e.Amount,
+		})
+	}
+	return s, nil
+}
+
+// getStatement renders a statement on demand; it defaults to the last
+// complete month.
+func getStatement(c *gin.Context) {
+	ctx := c.Request.Context()
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	workspace, ok := findCurrentWorkspace(c)
+	if !ok {
+		return
+	}
+	loc := locationFor(user.Timezone)
+	now := time.Now()
+	period := startOfMonth(now.In(loc)).AddDate(0, -1, 0)
+	if v := c.Query("month"); v != "" {
+		t, err := time.ParseInLocation(monthLayout, v, loc)
+		if err != nil {
+			problem.Render(c, problem.New(http.StatusBadRequest, "Invalid month").
+				WithCode(problem.CodeValidationFailed).
+				WithField("month", "must be formatted as YYYY-MM"))
+			return
+		}
+		period = t
+	}
+	s, err := buildStatement(ctx, user, workspace, period, now)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to build statement")
+		return
+	}
+	filename := "statement-" + s.PeriodKey()
+	switch c.DefaultQuery("format", "html") {
+	case "pdf":
+		pdf, err := statements.RenderPDF(s)
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Failed to render statement")
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.pdf"`)
+		c.Data(http.StatusOK, "application/pdf", pdf)
+	case "html":
+		html, err := statements.RenderHTML(s)
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Failed to render statement")
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", html)
+	default:
+		problem.Render(c, problem.New(http.StatusBadRequest, "Invalid format").
+			WithCode(problem.CodeValidationFailed).
+			WithField("format", "must be html or pdf"))
+	}
+}
+
+func listStatementRuns(c *gin.Context) {
+	ctx := c.Request.Context()
+	opts := options.Find().SetSort(bson.M{"period": -1}).SetLimit(24)
+	cur, err := StatementCollection.Find(ctx, bson.M{"user_id": currentUserID(c)}, opts)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch statements")
+		return
+	}
+	runs := []StatementRun{}
+	if err := cur.All(ctx, &runs); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch statements")
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
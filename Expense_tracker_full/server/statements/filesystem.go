@@ -0,0 +1,39 @@
+package statements
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FileDeliverer writes statements under Dir/<user id>/<YYYY-MM>.{html,pdf}.
+// It stands in for email delivery in development and tests.
+type FileDeliverer struct {
+	Dir string
+}
+
+func (f FileDeliverer) Deliver(ctx context.Context, d Delivery) error {
+	dir := filepath.Join(f.Dir, d.Statement.UserID.Hex())
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("create statement directory: %w", err)
+	}
+	base := filepath.Join(dir, d.Statement.PeriodKey())
+	if err := writeFile(base+".html", d.HTML); err != nil {
+		return err
+	}
+	return writeFile(base+".pdf", d.PDF)
+}
+
+// writeFile goes through a temporary file so a crash never leaves a
+// truncated statement behind.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package statements
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+)
+
+//go:embed statement.html.tmpl
+var htmlSource string
+
+var htmlTemplate = template.Must(template.New("statement").Funcs(template.FuncMap{
+	"money": money,
+	"deref": func(f *float64) float64 { return *f },
+}).Parse(htmlSource))
+
+func money(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
+
+func RenderHTML(s Statement) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := htmlTemplate.Execute(&buf, s); err != nil {
+		return nil, fmt.Errorf("render statement html: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderPDF lays out the same content as the HTML statement using the PDF
+// core fonts, so no font files need to ship with the server.
+func RenderPDF(s Statement) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetTitle("Statement "+s.PeriodKey(), true)
+	pdf.SetMargins(18, 18, 18)
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 9, tr("Spending statement - "+s.Period.Format("January 2006")), "", 1, "", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.SetTextColor(119, 119, 119)
+	pdf.CellFormat(0, 6, tr(s.Name()+" - amounts in "+s.Currency+" - "+s.Timezone), "", 1, "", false, 0, "")
+	pdf.SetTextColor(34, 34, 34)
+	pdf.Ln(4)
+
+	pdf.SetFont("Helvetica", "", 11)
+	for _, row := range []struct {
+		label string
+		value float64
+	}{{"Income", s.Income}, {"Spending", s.Total}, {"Net", s.Net()}} {
+		if row.label == "Net" {
+			pdf.SetFont("Helvetica", "B", 11)
+		}
+		pdf.CellFormat(40, 7, row.label, "", 0, "", false, 0, "")
+		pdf.CellFormat(40, 7, money(row.value), "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(6)
+
+	heading := func(text string) {
+		pdf.SetFont("Helvetica", "B", 13)
+		pdf.CellFormat(0, 8, text, "", 1, "", false, 0, "")
+		pdf.SetFont("Helvetica", "B", 9)
+	}
+	row := func(widths []float64, aligns string, cells ...string) {
+		for i, cell := range cells {
+			ln := 0
+			if i == len(cells)-1 {
+				ln = 1
+			}
+			pdf.CellFormat(widths[i], 7, tr(cell), "B", ln, string(aligns[i]), false, 0, "")
+		}
+	}
+
+	heading("By category")
+	if len(s.Categories) == 0 {
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.CellFormat(0, 7, "No expenses were recorded this month.", "", 1, "", false, 0, "")
+	} else {
+		widths := []float64{60, 22, 30, 30, 32}
+		row(widths, "LRRRC", "Category", "Expenses", "Total", "Budget", "Status")
+		pdf.SetFont("Helvetica", "", 9)
+		for _, line := range s.Categories {
+			budget, status := "-", ""
+			if line.Budget != nil {
+				budget, status = money(*line.Budget), line.Status
+			}
+			category := line.Category
+			if category == "" {
+				category = "Uncategorised"
+			}
+			row(widths, "LRRRC", category, fmt.Sprint(line.Count), money(line.Total), budget, strings.ToUpper(status))
+		}
+	}
+	pdf.Ln(6)
+
+	if len(s.TopExpenses) > 0 {
+		heading("Largest expenses")
+		widths := []float64{22, 80, 40, 32}
+		row(widths, "LLLR", "Date", "Title", "Category", "Amount")
+		pdf.SetFont("Helvetica", "", 9)
+		for _, e := range s.TopExpenses {
+			row(widths, "LLLR", e.Date.Format("2 Jan"), truncate(e.Title, 48), truncate(e.Category, 24), money(e.Amount))
+		}
+	}
+
+	pdf.Ln(6)
+	pdf.SetFont("Helvetica", "", 8)
+	pdf.SetTextColor(119, 119, 119)
+	pdf.CellFormat(0, 6, "Generated "+s.GeneratedAt.Format("2 Jan 2006 15:04 MST"), "", 1, "", false, 0, "")
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("render statement pdf: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "..."
+}
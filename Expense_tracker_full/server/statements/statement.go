@@ -0,0 +1,98 @@
+// Package statements renders monthly spending statements and hands them to a
+// Deliverer. Gathering the figures is left to the caller so the package has no
+// knowledge of the database.
+package statements
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	BudgetOK      = "ok"
+	BudgetWarning = "warning"
+	BudgetOver    = "over"
+
+	// WarningThreshold is the share of a budget at which a category is
+	// flagged before it is actually exceeded.
+	WarningThreshold = 0.8
+)
+
+type Statement struct {
+	UserID      primitive.ObjectID
+	Email       string
+	DisplayName string
+	Workspace   string
+	Currency    string
+	Timezone    string
+	Period      time.Time
+	GeneratedAt time.Time
+
+	Income      float64
+	Total       float64
+	Categories  []CategoryLine
+	TopExpenses []ExpenseLine
+}
+
+type CategoryLine struct {
+	Category string
+	Count    int64
+	Total    float64
+	Budget   *float64
+	Status   string
+}
+
+type ExpenseLine struct {
+	Date     time.Time
+	Title    string
+	Category string
+	Amount   float64
+}
+
+// BudgetStatus classifies spending against a monthly limit.
+func BudgetStatus(spent, limit float64) string {
+	switch {
+	case spent > limit:
+		return BudgetOver
+	case spent >= limit*WarningThreshold:
+		return BudgetWarning
+	default:
+		return BudgetOK
+	}
+}
+
+// PeriodKey is the YYYY-MM label used in file names and run records.
+func (s Statement) PeriodKey() string {
+	return s.Period.Format("2006-01")
+}
+
+func (s Statement) Net() float64 {
+	return s.Income - s.Total
+}
+
+// Name labels the statement with its recipient and, for a shared workspace,
+// the workspace it covers.
+func (s Statement) Name() string {
+	name := s.Email
+	if s.DisplayName != "" {
+		name = s.DisplayName
+	}
+	if s.Workspace != "" {
+		name += " - " + s.Workspace
+	}
+	return name
+}
+
+type Delivery struct {
+	Statement Statement
+	HTML      []byte
+	PDF       []byte
+}
+
+// Deliverer sends a rendered statement to its recipient. Implementations
+// must be safe to retry: the scheduler redelivers after a failure.
+type Deliverer interface {
+	Deliver(ctx context.Context, d Delivery) error
+}
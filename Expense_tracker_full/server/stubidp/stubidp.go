@@ -0,0 +1,175 @@
+// Package stubidp is a minimal OpenID Connect provider for exercising the
+// expense server's single sign-on flow, locally through cmd/stubidp and in
+// tests. It approves every authorization request for a single user.
+package stubidp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v4"
+)
+
+type authCode struct {
+	ClientID      string
+	RedirectURI   string
+	Nonce         string
+	Challenge     string
+	ChallengeType string
+	ExpiresAt     time.Time
+}
+
+// Provider signs ID tokens for Email. Issuer must be the URL it is served
+// at; set it before the first request.
+type Provider struct {
+	Issuer        string
+	Email         string
+	EmailVerified bool
+
+	key    *rsa.PrivateKey
+	signer jose.Signer
+
+	mutex sync.Mutex
+	codes map[string]authCode
+}
+
+func New(issuer, email string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "stub"}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{
+		Issuer:        issuer,
+		Email:         email,
+		EmailVerified: true,
+		key:           key,
+		signer:        signer,
+		codes:         make(map[string]authCode),
+	}, nil
+}
+
+// Routes registers the discovery, key, authorization and token endpoints.
+func (p *Provider) Routes(r gin.IRoutes) {
+	r.GET("/.well-known/openid-configuration", p.HandleDiscovery)
+	r.GET("/keys", p.HandleKeys)
+	r.GET("/authorize", p.HandleAuthorize)
+	r.POST("/token", p.HandleToken)
+}
+
+func (p *Provider) HandleDiscovery(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) HandleKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &p.key.PublicKey, KeyID: "stub", Algorithm: string(jose.RS256), Use: "sig"},
+	}})
+}
+
+func (p *Provider) HandleAuthorize(c *gin.Context) {
+	redirectURI := c.Query("redirect_uri")
+	if c.Query("response_type") != "code" || redirectURI == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	code := hex.EncodeToString(buf)
+
+	p.mutex.Lock()
+	p.codes[code] = authCode{
+		ClientID:      c.Query("client_id"),
+		RedirectURI:   redirectURI,
+		Nonce:         c.Query("nonce"),
+		Challenge:     c.Query("code_challenge"),
+		ChallengeType: c.Query("code_challenge_method"),
+		ExpiresAt:     time.Now().Add(time.Minute),
+	}
+	p.mutex.Unlock()
+
+	query := url.Values{"code": {code}, "state": {c.Query("state")}}
+	c.Redirect(http.StatusFound, redirectURI+"?"+query.Encode())
+}
+
+func (p *Provider) HandleToken(c *gin.Context) {
+	code := c.PostForm("code")
+	p.mutex.Lock()
+	grant, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mutex.Unlock()
+
+	if c.PostForm("grant_type") != "authorization_code" || !ok || time.Now().After(grant.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant"})
+		return
+	}
+	if grant.RedirectURI != c.PostForm("redirect_uri") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant"})
+		return
+	}
+	sum := sha256.Sum256([]byte(c.PostForm("code_verifier")))
+	if grant.ChallengeType != "S256" || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.Challenge {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	clientID := grant.ClientID
+	if id, _, ok := c.Request.BasicAuth(); ok {
+		clientID = id
+	}
+	now := time.Now()
+	claims := map[string]any{
+		"iss":            p.Issuer,
+		"sub":            "stub|" + p.Email,
+		"aud":            clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          grant.Nonce,
+		"email_verified": p.EmailVerified,
+		"name":           "Stub User",
+	}
+	if p.Email != "" {
+		claims["email"] = p.Email
+	}
+	payload, _ := json.Marshal(claims)
+	jws, err := p.signer.Sign(payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	idToken, err := jws.CompactSerialize()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": code,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
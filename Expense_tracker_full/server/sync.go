@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"gin-app/events"
+	"gin-app/middleware"
+	"gin-app/migrations"
+	"gin-app/problem"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultSyncLimit = 500
+	maxSyncLimit     = 1000
+	maxSyncBatch     = 100
+
+	SyncApplied  = "applied"
+	SyncConflict = "conflict"
+	SyncRejected = "rejected"
+)
+
+var TombstoneCollection *mongo.Collection
+
+// Tombstone stands in for a deleted expense in the change feed so offline
+// clients learn about the deletion. It shares the expense's ID.
+type Tombstone struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	WorkspaceID primitive.ObjectID `bson:"workspace_id"`
+	Revision    int64              `bson:"revision"`
+	SyncSeq     int64              `bson:"sync_seq"`
+	DeletedAt   time.Time          `bson:"deleted_at"`
+}
+
+// SyncChange is one entry of the change feed: the expense as it is now, or a
+// deletion marker.
+type SyncChange struct {
+	ID       primitive.ObjectID `json:"id"`
+	Revision int64              `json:"revision"`
+	Deleted  bool               `json:"deleted"`
+	Expense  *Expense           `json:"expense,omitempty"`
+	seq      int64
+}
+
+type SyncPush struct {
+	Changes []SyncPushChange `json:"changes"`
+}
+
+// SyncPushChange is a change made offline. BaseRevision is the revision the
+// client last saw, 0 for an expense it created; ID is always client-chosen so
+// retries of a create don't duplicate it.
+type SyncPushChange struct {
+	ID           string   `json:"id"`
+	BaseRevision int64    `json:"base_revision"`
+	Deleted      bool     `json:"deleted"`
+	Expense      *Expense `json:"expense"`
+}
+
+type SyncResult struct {
+	ID       string               `json:"id"`
+	Status   string               `json:"status"`
+	Revision int64                `json:"revision,omitempty"`
+	Deleted  bool                 `json:"deleted,omitempty"`
+	Expense  *Expense             `json:"expense,omitempty"`
+	Code     string               `json:"code,omitempty"`
+	Errors   []problem.FieldError `json:"errors,omitempty"`
+}
+
+// syncReservationTTL bounds how long a reserved sequence number holds back
+// the change feed. Writes finish well within it; a reservation older than
+// this belongs to a request that died before releasing it.
+const syncReservationTTL = time.Minute
+
+// maxSyncSeqAttempts bounds how many times nextSyncSeq retries when other
+// writes in the workspace keep taking the number it read.
+const maxSyncSeqAttempts = 20
+
+var errSyncSeqContention = errors.New("sync: gave up reserving a sequence number under contention")
+
+// nextSyncSeq reserves the workspace's next change sequence number. Writes
+// can commit out of order, so the number stays in the workspace's
+// sync_reservations, keyed by number with the time it was reserved, until
+// done is called, and pullChanges does not read past it in the meantime.
+// Call done once the write has committed or failed. Reservations left behind
+// by requests that died are dropped as new ones are made.
+func nextSyncSeq(ctx context.Context, workspaceID primitive.ObjectID) (seq int64, done func(), err error) {
+	for attempt := 1; ; attempt++ {
+		if attempt > maxSyncSeqAttempts {
+			return 0, nil, errSyncSeqContention
+		}
+		var workspace struct {
+			SyncSeq      int64                `bson:"sync_seq"`
+			Reservations map[string]time.Time `bson:"sync_reservations"`
+		}
+		opts := options.FindOne().SetProjection(bson.M{"sync_seq": 1, "sync_reservations": 1})
+		if err := WorkspaceCollection.FindOne(ctx, bson.M{"_id": workspaceID}, opts).Decode(&workspace); err != nil {
+			return 0, nil, err
+		}
+		// Compare and swap rather than $inc, so the number and its
+		// reservation are recorded in the same write.
+		seq = workspace.SyncSeq + 1
+		now := time.Now()
+		update := bson.M{"$set": bson.M{
+			"sync_seq": seq,
+			"sync_reservations." + strconv.FormatInt(seq, 10): now,
+		}}
+		expired := bson.M{}
+		for key, reservedAt := range workspace.Reservations {
+			if reservedAt.Before(now.Add(-syncReservationTTL)) {
+				expired["sync_reservations."+key] = ""
+			}
+		}
+		if len(expired) > 0 {
+			update["$unset"] = expired
+		}
+		var current any = workspace.SyncSeq
+		if workspace.SyncSeq == 0 {
+			current = bson.M{"$in": bson.A{0, nil}}
+		}
+		res, err := WorkspaceCollection.UpdateOne(ctx, bson.M{"_id": workspaceID, "sync_seq": current}, update)
+		if err != nil {
+			return 0, nil, err
+		}
+		if res.MatchedCount == 1 {
+			break
+		}
+	}
+	done = func() {
+		// The request may be cancelled by now; the reservation must go
+		// regardless.
+		ctx := context.WithoutCancel(ctx)
+		_, err := WorkspaceCollection.UpdateOne(ctx,
+			bson.M{"_id": workspaceID},
+			bson.M{"$unset": bson.M{"sync_reservations." + strconv.FormatInt(seq, 10): ""}},
+		)
+		if err != nil {
+			middleware.Logger(ctx).Error("release sync seq", "workspace_id", workspaceID.Hex(), "seq", seq, "error", err)
+		}
+	}
+	return seq, done, nil
+}
+
+// stampChange adds a fresh sequence number and a revision bump to update, so
+// every write to an expense shows up in the change feed. Call done once the
+// update has been applied or has failed.
+func stampChange(ctx context.Context, workspaceID primitive.ObjectID, update bson.M) (done func(), err error) {
+	seq, done, err := nextSyncSeq(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	set, ok := update["$set"].(bson.M)
+	if !ok {
+		set = bson.M{}
+		update["$set"] = set
+	}
+	set["sync_seq"] = seq
+	update["$inc"] = bson.M{"revision": 1}
+	return done, nil
+}
+
+// syncHorizon is the highest sequence number below which every change in the
+// workspace has committed, so a pull can read up to it without skipping a
+// write that lands later with a lower number.
+func syncHorizon(ctx context.Context, workspaceID primitive.ObjectID) (int64, error) {
+	var workspace struct {
+		SyncSeq      int64                `bson:"sync_seq"`
+		Reservations map[string]time.Time `bson:"sync_reservations"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"sync_seq": 1, "sync_reservations": 1})
+	if err := WorkspaceCollection.FindOne(ctx, bson.M{"_id": workspaceID}, opts).Decode(&workspace); err != nil {
+		return 0, err
+	}
+	horizon := workspace.SyncSeq
+	stale := time.Now().Add(-syncReservationTTL)
+	for key, reservedAt := range workspace.Reservations {
+		seq, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			continue
+		}
+		if reservedAt.After(stale) && seq <= horizon {
+			horizon = seq - 1
+		}
+	}
+	return horizon, nil
+}
+
+func recordTombstone(ctx context.Context, deleted Expense) {
+	log := middleware.Logger(ctx)
+	seq, done, err := nextSyncSeq(ctx, deleted.WorkspaceID)
+	if err != nil {
+		log.Error("record tombstone", "id", deleted.ID.Hex(), "error", err)
+		return
+	}
+	defer done()
+	_, err = TombstoneCollection.ReplaceOne(ctx, bson.M{"_id": deleted.ID}, Tombstone{
+		ID:          deleted.ID,
+		WorkspaceID: deleted.WorkspaceID,
+		Revision:    deleted.Revision + 1,
+		SyncSeq:     seq,
+		DeletedAt:   time.Now(),
+	}, options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Error("record tombstone", "id", deleted.ID.Hex(), "error", err)
+	}
+}
+
+// pullChanges returns the workspace's changes after checkpoint in sequence
+// order. Each expense appears at most once, in its latest state. Clients
+// store the returned checkpoint and keep pulling while more is set. Changes
+// whose sequence number lies above a write still in flight are held back
+// until that write commits, so the checkpoint never passes it.
+func pullChanges(c *gin.Context) {
+	ctx := c.Request.Context()
+	checkpoint, err := strconv.ParseInt(c.DefaultQuery("checkpoint", "0"), 10, 64)
+	if err != nil || checkpoint < 0 {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Invalid checkpoint").
+			WithCode(problem.CodeValidationFailed).
+			WithField("checkpoint", "must be a non-negative integer"))
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSyncLimit)))
+	if err != nil || limit < 1 || limit > maxSyncLimit {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Invalid limit").
+			WithCode(problem.CodeValidationFailed).
+			WithField("limit", "must be between 1 and 1000"))
+		return
+	}
+
+	horizon, err := syncHorizon(ctx, currentWorkspaceID(c))
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch changes")
+		return
+	}
+	filter := bson.M{"workspace_id": currentWorkspaceID(c), "sync_seq": bson.M{"$gt": checkpoint, "$lte": horizon}}
+	opts := options.Find().SetSort(bson.M{"sync_seq": 1}).SetLimit(int64(limit + 1))
+	var expenses []Expense
+	cur, err := collection.Find(ctx, filter, opts)
+	if err == nil {
+		err = cur.All(ctx, &expenses)
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch changes")
+		return
+	}
+	var tombstones []Tombstone
+	cur, err = TombstoneCollection.Find(ctx, filter, opts)
+	if err == nil {
+		err = cur.All(ctx, &tombstones)
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch changes")
+		return
+	}
+
+	changes := make([]SyncChange, 0, len(expenses)+len(tombstones))
+	for i := range expenses {
+		e := &expenses[i]
+		changes = append(changes, SyncChange{ID: e.ID, Revision: e.Revision, Expense: e, seq: e.SyncSeq})
+	}
+	for _, t := range tombstones {
+		changes = append(changes, SyncChange{ID: t.ID, Revision: t.Revision, Deleted: true, seq: t.SyncSeq})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].seq < changes[j].seq })
+	more := len(changes) > limit
+	if more {
+		changes = changes[:limit]
+	}
+	if len(changes) > 0 {
+		checkpoint = changes[len(changes)-1].seq
+	}
+	c.JSON(http.StatusOK, gin.H{"checkpoint": checkpoint, "changes": changes, "more": more})
+}
+
+// pushChanges applies a batch of offline changes in order. Conflicts are
+// resolved the same way every time:
+//
+//   - a change whose base revision matches the server's is applied;
+//   - otherwise the server copy wins and is returned, and the client should
+//     rebase its edit onto it and push again;
+//   - a deletion on the server wins over any edit to the same expense, while
+//     deleting an expense that is already gone succeeds;
+//   - expenses in review are locked, as they are for PUT and DELETE.
+//
+// A failure on one change does not stop the rest of the batch.
+func pushChanges(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req SyncPush
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Changes) > maxSyncBatch {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Too many changes").
+			WithCode(problem.CodeValidationFailed).
+			WithField("changes", "at most 100 changes can be pushed at once"))
+		return
+	}
+	workspace, ok := findCurrentWorkspace(c)
+	if !ok {
+		return
+	}
+	results := make([]SyncResult, 0, len(req.Changes))
+	for _, ch := range req.Changes {
+		res, err := applySyncChange(ctx, workspace, currentUserID(c), ch)
+		if err != nil {
+			middleware.Logger(ctx).Error("apply sync change", "id", ch.ID, "error", err)
+			res = SyncResult{ID: ch.ID, Status: SyncRejected, Code: problem.CodeInternal}
+		}
+		results = append(results, res)
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+func applySyncChange(ctx context.Context, workspace Workspace, userID primitive.ObjectID, ch SyncPushChange) (SyncResult, error) {
+	res := SyncResult{ID: ch.ID, Status: SyncRejected}
+	id, err := primitive.ObjectIDFromHex(ch.ID)
+	if err != nil {
+		res.Code = problem.CodeValidationFailed
+		res.Errors = []problem.FieldError{{Field: "id", Message: "must be an ObjectID"}}
+		return res, nil
+	}
+	if !ch.Deleted && ch.Expense == nil {
+		res.Code = problem.CodeValidationFailed
+		res.Errors = []problem.FieldError{{Field: "expense", Message: "is required unless deleted is set"}}
+		return res, nil
+	}
+
+	var current Expense
+	err = collection.FindOne(ctx, bson.M{"_id": id, "workspace_id": workspace.ID}).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		var t Tombstone
+		err = TombstoneCollection.FindOne(ctx, bson.M{"_id": id, "workspace_id": workspace.ID}).Decode(&t)
+		switch {
+		case err == nil:
+			res.Revision, res.Deleted = t.Revision, true
+			res.Status = SyncConflict
+			if ch.Deleted {
+				res.Status = SyncApplied
+			}
+			return res, nil
+		case err != mongo.ErrNoDocuments:
+			return res, err
+		case ch.Deleted || ch.BaseRevision != 0:
+			res.Code = problem.CodeNotFound
+			return res, nil
+		}
+		return createSyncedExpense(ctx, workspace, userID, id, *ch.Expense)
+	}
+	if err != nil {
+		return res, err
+	}
+
+	if current.Revision != ch.BaseRevision {
+		res.Status, res.Revision, res.Expense = SyncConflict, current.Revision, &current
+		return res, nil
+	}
+	if !slices.Contains(editableStatuses, current.Status) {
+		res.Code, res.Revision, res.Expense = "expense_locked", current.Revision, &current
+		return res, nil
+	}
+	filter := bson.M{"_id": id, "workspace_id": workspace.ID, "revision": current.Revision, "status": bson.M{"$in": editableStatuses}}
+
+	if ch.Deleted {
+		var deleted Expense
+		err := collection.FindOneAndDelete(ctx, filter).Decode(&deleted)
+		if err == mongo.ErrNoDocuments {
+			// Changed between the read and the delete; the client pulls and
+			// retries.
+			res.Status = SyncConflict
+			return res, nil
+		}
+		if err != nil {
+			return res, err
+		}
+		untrackExpense(ctx, deleted)
+		recordTombstone(ctx, deleted)
+		publishExpense(events.ExpenseDeleted, workspace.ID, id, nil)
+		res.Status, res.Revision, res.Deleted = SyncApplied, deleted.Revision+1, true
+		return res, nil
+	}
+
+	updated := *ch.Expense
+	if p := validateSyncedExpense(&updated, &current, workspace.Rates); p != nil {
+		res.Code, res.Errors = problem.CodeValidationFailed, p.Errors
+		return res, nil
+	}
+	if p, err := matchMerchant(ctx, workspace.ID, &updated); p != nil || err != nil {
+		if p != nil {
+			res.Code, res.Errors = problem.CodeValidationFailed, p.Errors
+		}
+		return res, err
+	}
+	update := expenseUpdate(id, updated)
+	done, err := stampChange(ctx, workspace.ID, update)
+	if err != nil {
+		return res, err
+	}
+	defer done()
+	var previous Expense
+	err = collection.FindOneAndUpdate(ctx, filter, update).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		res.Status = SyncConflict
+		return res, nil
+	}
+	if err != nil {
+		return res, err
+	}
+	saved := withEdits(previous, updated)
+	trackExpense(ctx, saved, &previous)
+	publishExpense(events.ExpenseUpdated, workspace.ID, id, saved)
+	res.Status, res.Revision = SyncApplied, saved.Revision
+	return res, nil
+}
+
+// createSyncedExpense inserts an expense created offline under the client's
+// ID. Unlike POST /expense it keeps the client's date, since the expense may
+// have been recorded well before it reached the server.
+func createSyncedExpense(ctx context.Context, workspace Workspace, userID, id primitive.ObjectID, e Expense) (SyncResult, error) {
+	res := SyncResult{ID: id.Hex(), Status: SyncRejected}
+	if p := validateSyncedExpense(&e, nil, workspace.Rates); p != nil {
+		res.Code, res.Errors = problem.CodeValidationFailed, p.Errors
+		return res, nil
+	}
+	if p, err := matchMerchant(ctx, workspace.ID, &e); p != nil || err != nil {
+		if p != nil {
+			res.Code, res.Errors = problem.CodeValidationFailed, p.Errors
+		}
+		return res, err
+	}
+	seq, done, err := nextSyncSeq(ctx, workspace.ID)
+	if err != nil {
+		return res, err
+	}
+	defer done()
+	e.ID = id
+	e.WorkspaceID = workspace.ID
+	e.UserID = userID
+	if e.Date.IsZero() || e.Date.After(time.Now()) {
+		e.Date = time.Now()
+	}
+	e.Status = StatusDraft
+	e.ApproverID = nil
+	e.StatusChangedAt = nil
+	e.Comments = nil
+	e.Revision = 1
+	e.SyncSeq = seq
+	e.SchemaVersion = migrations.ExpenseSchemaVersion
+	if _, err := collection.InsertOne(ctx, e); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// The ID belongs to an expense in another workspace.
+			res.Code = problem.CodeConflict
+			return res, nil
+		}
+		return res, err
+	}
+	trackExpense(ctx, e, nil)
+	publishExpense(events.ExpenseCreated, workspace.ID, id, e)
+	res.Status, res.Revision = SyncApplied, e.Revision
+	return res, nil
+}
+
+// validateSyncedExpense applies the checks POST and PUT /expense make,
+// collecting every problem instead of stopping at the first. previous is the
+// saved expense for an edit, and nil for a new one.
+func validateSyncedExpense(e, previous *Expense, rates RateTables) *problem.Problem {
+	if p := priceExpense(e, previous, rates); p != nil {
+		return p
+	}
+	e.Tags = normaliseTags(e.Tags)
+	p := problem.New(http.StatusBadRequest, "Invalid expense").WithCode(problem.CodeValidationFailed)
+	if e.Title == "" {
+		p.WithField("title", "is required")
+	}
+	if e.Amount <= 0 {
+		p.WithField("amount", "must be greater than 0")
+	}
+	if len(e.Tags) > maxTags {
+		p.WithField("tags", "at most 20 tags are allowed")
+	}
+	for _, tag := range e.Tags {
+		if len(tag) > maxTagLength {
+			p.WithField("tags", "tags must be at most 40 characters")
+			break
+		}
+	}
+	if len(p.Errors) > 0 {
+		return p
+	}
+	return nil
+}
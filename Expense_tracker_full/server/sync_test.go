@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type syncFeed struct {
+	Checkpoint int64        `json:"checkpoint"`
+	Changes    []SyncChange `json:"changes"`
+}
+
+func pull(t *testing.T, workspaceID primitive.ObjectID, checkpoint int64) syncFeed {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/sync?checkpoint="+strconv.FormatInt(checkpoint, 10), nil)
+	c.Set("workspace_id", workspaceID)
+	pullChanges(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("pull: status %d: %s", w.Code, w.Body)
+	}
+	var feed syncFeed
+	if err := json.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	return feed
+}
+
+func insertAt(t *testing.T, workspaceID primitive.ObjectID, seq int64) primitive.ObjectID {
+	t.Helper()
+	e := Expense{
+		ID:          primitive.NewObjectID(),
+		WorkspaceID: workspaceID,
+		Title:       "Lunch",
+		Amount:      12,
+		Date:        time.Now(),
+		Status:      StatusDraft,
+		Revision:    1,
+		SyncSeq:     seq,
+	}
+	if _, err := collection.InsertOne(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	return e.ID
+}
+
+// A write that reserved its sequence number first but commits last must not
+// be skipped by a pull that sees the later write.
+func TestPullWaitsForUncommittedWrites(t *testing.T) {
+	testDatabase(t)
+	ctx := context.Background()
+	workspaceID, err := createPersonalWorkspace(ctx, primitive.NewObjectID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, doneFirst, err := nextSyncSeq(ctx, workspaceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, doneSecond, err := nextSyncSeq(ctx, workspaceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first+1 {
+		t.Fatalf("sequence numbers %d then %d", first, second)
+	}
+	secondID := insertAt(t, workspaceID, second)
+	doneSecond()
+
+	feed := pull(t, workspaceID, 0)
+	if len(feed.Changes) != 0 || feed.Checkpoint != 0 {
+		t.Fatalf("pull while %d is in flight: checkpoint %d, %d changes", first, feed.Checkpoint, len(feed.Changes))
+	}
+
+	firstID := insertAt(t, workspaceID, first)
+	doneFirst()
+
+	feed = pull(t, workspaceID, feed.Checkpoint)
+	if feed.Checkpoint != second {
+		t.Fatalf("checkpoint = %d, want %d", feed.Checkpoint, second)
+	}
+	if len(feed.Changes) != 2 || feed.Changes[0].ID != firstID || feed.Changes[1].ID != secondID {
+		t.Fatalf("changes = %+v, want %s then %s", feed.Changes, firstID.Hex(), secondID.Hex())
+	}
+}
+
+// A reservation left behind by a request that died holds the feed back only
+// until it goes stale.
+func TestPullIgnoresStaleReservations(t *testing.T) {
+	testDatabase(t)
+	ctx := context.Background()
+	workspaceID, err := createPersonalWorkspace(ctx, primitive.NewObjectID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	abandoned, _, err := nextSyncSeq(ctx, workspaceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = WorkspaceCollection.UpdateOne(ctx,
+		bson.M{"_id": workspaceID},
+		bson.M{"$set": bson.M{"sync_reservations." + strconv.FormatInt(abandoned, 10): time.Now().Add(-2 * syncReservationTTL)}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq, done, err := nextSyncSeq(ctx, workspaceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := insertAt(t, workspaceID, seq)
+	done()
+
+	feed := pull(t, workspaceID, 0)
+	if feed.Checkpoint != seq || len(feed.Changes) != 1 || feed.Changes[0].ID != id {
+		t.Fatalf("checkpoint %d, changes %+v; want %d and %s", feed.Checkpoint, feed.Changes, seq, id.Hex())
+	}
+
+	// The next reservation drops the stale one, and done its own.
+	var workspace struct {
+		Reservations map[string]time.Time `bson:"sync_reservations"`
+	}
+	if err := WorkspaceCollection.FindOne(ctx, bson.M{"_id": workspaceID}).Decode(&workspace); err != nil {
+		t.Fatal(err)
+	}
+	if len(workspace.Reservations) != 0 {
+		t.Errorf("reservations left behind: %v", workspace.Reservations)
+	}
+}
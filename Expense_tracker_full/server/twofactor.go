@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"gin-app/middleware"
+	"gin-app/problem"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	totpIssuer        = "Expense Tracker"
+	totpPeriod        = 30
+	totpDigits        = 6
+	recoveryCodeCount = 10
+	twoFactorTTL      = 5 * time.Minute
+)
+
+type TwoFactorCode struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorLogin struct {
+	Token        string `json:"token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func newTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf), nil
+}
+
+func totpAt(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// verifyTOTP accepts the current time step and one step either side to
+// tolerate clock drift, returning the matched step so callers can reject
+// replays of an already used code.
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for _, step := range []int64{current - 1, current, current + 1} {
+		expected, err := totpAt(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func provisioningURI(secret, account string) string {
+	label := url.PathEscape(totpIssuer + ":" + account)
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {totpIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+func newRecoveryCodes() (plain []string, hashed []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := randomString(5)
+		if err != nil {
+			return nil, nil, err
+		}
+		code = code[:5] + "-" + code[5:]
+		plain = append(plain, code)
+		hashed = append(hashed, middleware.HashToken(code))
+	}
+	return plain, hashed, nil
+}
+
+// consumeTOTP checks a code against the user's active secret and records the
+// step atomically so the same code cannot be used twice.
+func consumeTOTP(ctx context.Context, user SignupUser, code string) (bool, error) {
+	step, ok := verifyTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	res, err := UserDataCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "totp_last_step": bson.M{"$not": bson.M{"$gte": step}}},
+		bson.M{"$set": bson.M{"totp_last_step": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func consumeRecoveryCode(ctx context.Context, user SignupUser, code string) (bool, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	res, err := UserDataCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "recovery_codes": middleware.HashToken(code)},
+		bson.M{"$pull": bson.M{"recovery_codes": middleware.HashToken(code)}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// createTwoFactorChallenge records a login waiting for its second factor.
+// reauthenticatedAt carries over to the session it becomes.
+func createTwoFactorChallenge(ctx context.Context, userID primitive.ObjectID, reauthenticatedAt *time.Time) (string, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	_, err = SessionCollection.InsertOne(ctx, middleware.Session{
+		UserID:            userID,
+		TokenHash:         middleware.HashToken(token),
+		Pending2FA:        true,
+		ReauthenticatedAt: reauthenticatedAt,
+		CreatedAt:         now,
+		ExpiresAt:         now.Add(twoFactorTTL),
+	})
+	return token, err
+}
+
+func LoginTwoFactor(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req TwoFactorLogin
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	var challenge middleware.Session
+	err := SessionCollection.FindOneAndDelete(ctx, bson.M{
+		"token_hash":  middleware.HashToken(req.Token),
+		"pending_2fa": true,
+		"expires_at":  bson.M{"$gt": time.Now()},
+	}).Decode(&challenge)
+	if err == mongo.ErrNoDocuments {
+		problem.Abort(c, http.StatusUnauthorized, "Invalid or expired two-factor challenge")
+		return
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to verify challenge")
+		return
+	}
+	var user SignupUser
+	if err := UserDataCollection.FindOne(ctx, bson.M{"_id": challenge.UserID}).Decode(&user); err != nil {
+		problem.Abort(c, http.StatusUnauthorized, "Invalid or expired two-factor challenge")
+		return
+	}
+	// The account may have been disabled or deleted since the password step.
+	if user.Disabled || user.DeletedAt != nil {
+		problem.Render(c, problem.New(http.StatusForbidden, "Account is disabled").WithCode("account_disabled"))
+		return
+	}
+	var ok bool
+	if req.RecoveryCode != "" {
+		ok, err = consumeRecoveryCode(ctx, user, req.RecoveryCode)
+	} else {
+		ok, err = consumeTOTP(ctx, user, req.Code)
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to verify code")
+		return
+	}
+	if !ok {
+		problem.Render(c, problem.New(http.StatusUnauthorized, "Invalid two-factor code").WithCode("invalid_two_factor_code"))
+		return
+	}
+	token, expiresAt, err := createSession(ctx, user.ID, challenge.ReauthenticatedAt)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to create session")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "expires_at": expiresAt})
+}
+
+func setupTwoFactor(c *gin.Context) {
+	ctx := c.Request.Context()
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		problem.Abort(c, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to generate secret")
+		return
+	}
+	_, err = UserDataCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+		"totp_pending_secret": secret,
+		"updated_at":          time.Now(),
+	}})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to start enrollment")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": provisioningURI(secret, user.Email),
+	})
+}
+
+func enableTwoFactor(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req TwoFactorCode
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPPendingSecret == "" {
+		problem.Abort(c, http.StatusBadRequest, "Start enrollment before enabling two-factor authentication")
+		return
+	}
+	step, valid := verifyTOTP(user.TOTPPendingSecret, req.Code, time.Now())
+	if !valid {
+		problem.Abort(c, http.StatusBadRequest, "Invalid two-factor code")
+		return
+	}
+	plain, hashed, err := newRecoveryCodes()
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to generate recovery codes")
+		return
+	}
+	_, err = UserDataCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{
+			"totp_secret":    user.TOTPPendingSecret,
+			"totp_enabled":   true,
+			"totp_last_step": step,
+			"recovery_codes": hashed,
+			"updated_at":     time.Now(),
+		},
+		"$unset": bson.M{"totp_pending_secret": ""},
+	})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": plain})
+}
+
+func disableTwoFactor(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req PasswordConfirmation
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	user, ok := findCurrentUser(c)
+	if !ok {
+		return
+	}
+	if user.Password == "" {
+		if !confirmPasswordless(c, user, req.Code) {
+			return
+		}
+	} else if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+		problem.Render(c, problem.New(http.StatusUnauthorized, "Password is incorrect").WithCode(problem.CodeInvalidCredentials))
+		return
+	}
+	_, err := UserDataCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set":   bson.M{"totp_enabled": false, "updated_at": time.Now()},
+		"$unset": bson.M{"totp_secret": "", "totp_pending_secret": "", "totp_last_step": "", "recovery_codes": ""},
+	})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
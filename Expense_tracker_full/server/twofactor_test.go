@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key from RFC 6238's test vectors, base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPAtMatchesRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		code, err := totpAt(rfcSecret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("at %d: code = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+	codeAt := func(step int64) string {
+		code, err := totpAt(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	tests := []struct {
+		name string
+		code string
+		step int64
+		ok   bool
+	}{
+		{name: "current step", code: codeAt(current), step: current, ok: true},
+		{name: "previous step", code: codeAt(current - 1), step: current - 1, ok: true},
+		{name: "next step", code: codeAt(current + 1), step: current + 1, ok: true},
+		{name: "two steps behind", code: codeAt(current - 2)},
+		{name: "two steps ahead", code: codeAt(current + 2)},
+		{name: "spaces are ignored", code: " " + codeAt(current)[:3] + " " + codeAt(current)[3:] + " ", step: current, ok: true},
+		{name: "too short", code: codeAt(current)[:5]},
+		{name: "too long", code: codeAt(current) + "0"},
+		{name: "empty", code: ""},
+		{name: "wrong code", code: "000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := verifyTOTP(rfcSecret, tt.code, now)
+			if ok != tt.ok || step != tt.step {
+				t.Errorf("verifyTOTP = (%d, %v), want (%d, %v)", step, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
+
+// consumeTOTP rejects a code whose step isn't after the last one used, so a
+// code replayed later in its window must report the step it was issued for.
+func TestVerifyTOTPReportsStepForReplay(t *testing.T) {
+	issued := time.Unix(1234567890, 0)
+	step := issued.Unix() / totpPeriod
+	code, err := totpAt(rfcSecret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, later := range []time.Duration{0, totpPeriod * time.Second, 2*totpPeriod*time.Second - time.Second} {
+		got, ok := verifyTOTP(rfcSecret, code, issued.Add(later))
+		if !ok || got != step {
+			t.Errorf("replayed %s later: verifyTOTP = (%d, %v), want (%d, true)", later, got, ok, step)
+		}
+	}
+	if _, ok := verifyTOTP(rfcSecret, code, issued.Add(3*totpPeriod*time.Second)); ok {
+		t.Error("code accepted after its window")
+	}
+	if _, ok := verifyTOTP("not base32!", code, issued); ok {
+		t.Error("code accepted for an undecodable secret")
+	}
+}
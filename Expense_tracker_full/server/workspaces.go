@@ -0,0 +1,651 @@
+package main
+
+import (
+	"context"
+	"gin-app/middleware"
+	"gin-app/problem"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	InvitationRevoked  = "revoked"
+
+	personalWorkspaceName = "Personal"
+	maxWorkspaceName      = 100
+)
+
+type WorkspaceMember struct {
+	UserID   primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Email    string             `json:"email,omitempty" bson:"-"`
+	Role     string             `json:"role" bson:"role"`
+	JoinedAt time.Time          `json:"joined_at" bson:"joined_at"`
+}
+
+// Workspace owns expenses, their categories and budgets. Every user has a
+// personal workspace that can't be shared or deleted; other workspaces are
+// shared with members by invitation.
+type Workspace struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name      string             `json:"name" bson:"name"`
+	Personal  bool               `json:"personal" bson:"personal"`
+	OwnerID   primitive.ObjectID `json:"owner_id" bson:"owner_id"`
+	Members   []WorkspaceMember  `json:"members" bson:"members"`
+	Budgets   map[string]float64 `json:"-" bson:"budgets"`
+	Rates     RateTables         `json:"-" bson:"rates"`
+	Role      string             `json:"role" bson:"-"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+type WorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+type MemberUpdate struct {
+	Role string `json:"role"`
+}
+
+type InvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type InvitationResponse struct {
+	Token string `json:"token"`
+}
+
+type Invitation struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	WorkspaceID   primitive.ObjectID `json:"workspace_id" bson:"workspace_id"`
+	WorkspaceName string             `json:"workspace_name" bson:"workspace_name"`
+	Email         string             `json:"email" bson:"email"`
+	Role          string             `json:"role" bson:"role"`
+	TokenHash     string             `json:"-" bson:"token_hash"`
+	InvitedBy     primitive.ObjectID `json:"invited_by" bson:"invited_by"`
+	Status        string             `json:"status" bson:"status"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt     time.Time          `json:"expires_at" bson:"expires_at"`
+	RespondedAt   *time.Time         `json:"responded_at,omitempty" bson:"responded_at,omitempty"`
+}
+
+var (
+	WorkspaceCollection  *mongo.Collection
+	InvitationCollection *mongo.Collection
+)
+
+func currentWorkspaceID(c *gin.Context) primitive.ObjectID {
+	id, _ := c.Get("workspace_id")
+	oid, _ := id.(primitive.ObjectID)
+	return oid
+}
+
+// personalWorkspaceID is the caller's own workspace, which also holds the
+// spending that their income and savings goals are measured against.
+func personalWorkspaceID(c *gin.Context) primitive.ObjectID {
+	id, _ := c.Get("default_workspace_id")
+	oid, _ := id.(primitive.ObjectID)
+	return oid
+}
+
+func (w Workspace) roleOf(userID primitive.ObjectID) string {
+	for _, m := range w.Members {
+		if m.UserID == userID {
+			return m.Role
+		}
+	}
+	return ""
+}
+
+// createPersonalWorkspace makes sure userID has a personal workspace and
+// that it is their default. The unique personal-workspace index turns a
+// concurrent second call into a no-op.
+func createPersonalWorkspace(ctx context.Context, userID primitive.ObjectID) (primitive.ObjectID, error) {
+	now := time.Now()
+	var workspace Workspace
+	err := WorkspaceCollection.FindOneAndUpdate(ctx,
+		bson.M{"owner_id": userID, "personal": true},
+		bson.M{"$setOnInsert": bson.M{
+			"name":       personalWorkspaceName,
+			"members":    []WorkspaceMember{{UserID: userID, Role: middleware.WorkspaceOwner, JoinedAt: now}},
+			"budgets":    bson.M{},
+			"created_at": now,
+			"updated_at": now,
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&workspace)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	_, err = UserDataCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"default_workspace_id": workspace.ID}})
+	return workspace.ID, err
+}
+
+// findCurrentWorkspace loads the workspace selected by WorkspaceMiddleware.
+func findCurrentWorkspace(c *gin.Context) (Workspace, bool) {
+	var workspace Workspace
+	err := WorkspaceCollection.FindOne(c.Request.Context(), bson.M{"_id": currentWorkspaceID(c)}).Decode(&workspace)
+	if err == mongo.ErrNoDocuments {
+		problem.Render(c, problem.New(http.StatusNotFound, "Workspace not found").WithCode("workspace_not_found"))
+		return workspace, false
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch workspace")
+		return workspace, false
+	}
+	workspace.Role = c.GetString("workspace_role")
+	return workspace, true
+}
+
+// findWorkspace loads the workspace named in the path for the management
+// routes, which don't go through WorkspaceMiddleware. With roles given, the
+// caller must hold one of them.
+func findWorkspace(c *gin.Context, roles ...string) (Workspace, bool) {
+	var workspace Workspace
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return workspace, false
+	}
+	userID := currentUserID(c)
+	err = WorkspaceCollection.FindOne(c.Request.Context(), bson.M{"_id": objID, "members.user_id": userID}).Decode(&workspace)
+	if err == mongo.ErrNoDocuments {
+		problem.Render(c, problem.New(http.StatusNotFound, "Workspace not found").WithCode("workspace_not_found"))
+		return workspace, false
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch workspace")
+		return workspace, false
+	}
+	workspace.Role = workspace.roleOf(userID)
+	if len(roles) > 0 && !slices.Contains(roles, workspace.Role) {
+		problem.Abort(c, http.StatusForbidden, "Insufficient workspace permissions")
+		return workspace, false
+	}
+	return workspace, true
+}
+
+func validWorkspaceName(c *gin.Context, name string) bool {
+	if name == "" || len(name) > maxWorkspaceName {
+		problem.Render(c, problem.New(http.StatusBadRequest, "Invalid workspace name").
+			WithCode(problem.CodeValidationFailed).
+			WithField("name", "must be between 1 and 100 characters"))
+		return false
+	}
+	return true
+}
+
+func listWorkspaces(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := currentUserID(c)
+	cur, err := WorkspaceCollection.Find(ctx, bson.M{"members.user_id": userID}, options.Find().SetSort(bson.D{{Key: "personal", Value: -1}, {Key: "created_at", Value: 1}}))
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch workspaces")
+		return
+	}
+	workspaces := []Workspace{}
+	if err := cur.All(ctx, &workspaces); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch workspaces")
+		return
+	}
+	for i := range workspaces {
+		workspaces[i].Role = workspaces[i].roleOf(userID)
+	}
+	c.JSON(http.StatusOK, workspaces)
+}
+
+func createWorkspace(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req WorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if !validWorkspaceName(c, req.Name) {
+		return
+	}
+	now := time.Now()
+	userID := currentUserID(c)
+	workspace := Workspace{
+		Name:      req.Name,
+		OwnerID:   userID,
+		Members:   []WorkspaceMember{{UserID: userID, Role: middleware.WorkspaceOwner, JoinedAt: now}},
+		Budgets:   map[string]float64{},
+		Role:      middleware.WorkspaceOwner,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	res, err := WorkspaceCollection.InsertOne(ctx, workspace)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to create workspace")
+		return
+	}
+	workspace.ID = res.InsertedID.(primitive.ObjectID)
+	c.JSON(http.StatusCreated, workspace)
+}
+
+// getWorkspace returns the workspace with its members' email addresses.
+func getWorkspace(c *gin.Context) {
+	ctx := c.Request.Context()
+	workspace, ok := findWorkspace(c)
+	if !ok {
+		return
+	}
+	ids := make([]primitive.ObjectID, len(workspace.Members))
+	for i, m := range workspace.Members {
+		ids[i] = m.UserID
+	}
+	cur, err := UserDataCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"email": 1}))
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch members")
+		return
+	}
+	var users []SignupUser
+	if err := cur.All(ctx, &users); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch members")
+		return
+	}
+	emails := make(map[primitive.ObjectID]string, len(users))
+	for _, u := range users {
+		emails[u.ID] = u.Email
+	}
+	for i := range workspace.Members {
+		workspace.Members[i].Email = emails[workspace.Members[i].UserID]
+	}
+	c.JSON(http.StatusOK, workspace)
+}
+
+func updateWorkspace(c *gin.Context) {
+	ctx := c.Request.Context()
+	workspace, ok := findWorkspace(c, middleware.WorkspaceOwner)
+	if !ok {
+		return
+	}
+	var req WorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if !validWorkspaceName(c, req.Name) {
+		return
+	}
+	_, err := WorkspaceCollection.UpdateOne(ctx, bson.M{"_id": workspace.ID}, bson.M{"$set": bson.M{
+		"name":       req.Name,
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update workspace")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Workspace updated"})
+}
+
+// deleteWorkspace removes a shared workspace along with everything it owns.
+func deleteWorkspace(c *gin.Context) {
+	workspace, ok := findWorkspace(c, middleware.WorkspaceOwner)
+	if !ok {
+		return
+	}
+	if workspace.Personal {
+		problem.Render(c, problem.New(http.StatusConflict, "Personal workspaces can't be deleted").WithCode("personal_workspace"))
+		return
+	}
+	if err := deleteWorkspaceData(c.Request.Context(), workspace.ID); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to delete workspace")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func deleteWorkspaceData(ctx context.Context, workspaceID primitive.ObjectID) error {
+	for _, coll := range []*mongo.Collection{collection, AnomalyCollection, CategoryStatsCollection, InvitationCollection, TombstoneCollection, MerchantCollection} {
+		if _, err := coll.DeleteMany(ctx, bson.M{"workspace_id": workspaceID}); err != nil {
+			return err
+		}
+	}
+	_, err := WorkspaceCollection.DeleteOne(ctx, bson.M{"_id": workspaceID})
+	return err
+}
+
+// leaveWorkspaces deletes the workspaces userID owns and removes them from
+// the rest. Callers must first make sure none of the owned workspaces has
+// other members.
+func leaveWorkspaces(ctx context.Context, userID primitive.ObjectID) error {
+	owned, err := WorkspaceCollection.Distinct(ctx, "_id", bson.M{"owner_id": userID})
+	if err != nil {
+		return err
+	}
+	for _, id := range owned {
+		if err := deleteWorkspaceData(ctx, id.(primitive.ObjectID)); err != nil {
+			return err
+		}
+	}
+	_, err = WorkspaceCollection.UpdateMany(ctx,
+		bson.M{"members.user_id": userID},
+		bson.M{"$pull": bson.M{"members": bson.M{"user_id": userID}}},
+	)
+	return err
+}
+
+func memberTargetID(c *gin.Context, workspace Workspace) (primitive.ObjectID, bool) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("user_id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return objID, false
+	}
+	if workspace.roleOf(objID) == "" {
+		problem.Abort(c, http.StatusNotFound, "Member not found")
+		return objID, false
+	}
+	return objID, true
+}
+
+// updateMember changes a member's role. Making someone the owner transfers
+// ownership; the previous owner stays on as an editor.
+func updateMember(c *gin.Context) {
+	ctx := c.Request.Context()
+	workspace, ok := findWorkspace(c, middleware.WorkspaceOwner)
+	if !ok {
+		return
+	}
+	targetID, ok := memberTargetID(c, workspace)
+	if !ok {
+		return
+	}
+	var req MemberUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !slices.Contains(middleware.AllWorkspaceRoles, req.Role) {
+		problem.Abort(c, http.StatusBadRequest, "Role must be one of owner, editor, viewer")
+		return
+	}
+	if targetID == currentUserID(c) {
+		problem.Abort(c, http.StatusBadRequest, "Transfer ownership to another member instead of changing your own role")
+		return
+	}
+	now := time.Now()
+	var err error
+	if req.Role == middleware.WorkspaceOwner {
+		_, err = WorkspaceCollection.UpdateOne(ctx,
+			bson.M{"_id": workspace.ID, "owner_id": currentUserID(c)},
+			bson.M{"$set": bson.M{
+				"owner_id":                 targetID,
+				"members.$[target].role":   middleware.WorkspaceOwner,
+				"members.$[previous].role": middleware.WorkspaceEditor,
+				"updated_at":               now,
+			}},
+			options.Update().SetArrayFilters(options.ArrayFilters{Filters: []any{
+				bson.M{"target.user_id": targetID},
+				bson.M{"previous.user_id": currentUserID(c)},
+			}}),
+		)
+	} else {
+		_, err = WorkspaceCollection.UpdateOne(ctx,
+			bson.M{"_id": workspace.ID, "members.user_id": targetID},
+			bson.M{"$set": bson.M{"members.$.role": req.Role, "updated_at": now}},
+		)
+	}
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to update member")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Member updated"})
+}
+
+// removeMember lets the owner remove anyone else, and any other member
+// leave. The owner has to transfer ownership or delete the workspace first.
+func removeMember(c *gin.Context) {
+	ctx := c.Request.Context()
+	workspace, ok := findWorkspace(c)
+	if !ok {
+		return
+	}
+	targetID, ok := memberTargetID(c, workspace)
+	if !ok {
+		return
+	}
+	if targetID != currentUserID(c) && workspace.Role != middleware.WorkspaceOwner {
+		problem.Abort(c, http.StatusForbidden, "Insufficient workspace permissions")
+		return
+	}
+	if targetID == workspace.OwnerID {
+		problem.Render(c, problem.New(http.StatusConflict, "Transfer ownership or delete the workspace before leaving").WithCode("workspace_owner"))
+		return
+	}
+	_, err := WorkspaceCollection.UpdateOne(ctx, bson.M{"_id": workspace.ID}, bson.M{
+		"$pull": bson.M{"members": bson.M{"user_id": targetID}},
+		"$set":  bson.M{"updated_at": time.Now()},
+	})
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to remove member")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// createInvitation invites an email address to the workspace. The token is
+// only ever returned here, for the owner to pass on; we store its hash.
+// Inviting the same address again replaces any pending invitation.
+func createInvitation(c *gin.Context) {
+	ctx := c.Request.Context()
+	workspace, ok := findWorkspace(c, middleware.WorkspaceOwner)
+	if !ok {
+		return
+	}
+	if workspace.Personal {
+		problem.Render(c, problem.New(http.StatusConflict, "Personal workspaces can't be shared").WithCode("personal_workspace"))
+		return
+	}
+	var req InvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Role == "" {
+		req.Role = middleware.WorkspaceEditor
+	}
+	p := problem.New(http.StatusBadRequest, "Invalid invitation").WithCode(problem.CodeValidationFailed)
+	if !middleware.ValidEmail(req.Email) {
+		p = p.WithField("email", "must be a valid email address")
+	}
+	if req.Role != middleware.WorkspaceEditor && req.Role != middleware.WorkspaceViewer {
+		p = p.WithField("role", "must be editor or viewer")
+	}
+	if len(p.Errors) > 0 {
+		problem.Render(c, p)
+		return
+	}
+	var invitee SignupUser
+	err := UserDataCollection.FindOne(ctx, bson.M{"email": req.Email}).Decode(&invitee)
+	if err != nil && err != mongo.ErrNoDocuments {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch user")
+		return
+	}
+	if err == nil && workspace.roleOf(invitee.ID) != "" {
+		problem.Render(c, problem.New(http.StatusConflict, "Already a member of this workspace").WithCode("already_member"))
+		return
+	}
+
+	now := time.Now()
+	_, err = InvitationCollection.UpdateMany(ctx,
+		bson.M{"workspace_id": workspace.ID, "email": req.Email, "status": InvitationPending},
+		bson.M{"$set": bson.M{"status": InvitationRevoked, "responded_at": now}},
+	)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to create invitation")
+		return
+	}
+	token, err := randomString(32)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to create invitation")
+		return
+	}
+	invitation := Invitation{
+		WorkspaceID:   workspace.ID,
+		WorkspaceName: workspace.Name,
+		Email:         req.Email,
+		Role:          req.Role,
+		TokenHash:     middleware.HashToken(token),
+		InvitedBy:     currentUserID(c),
+		Status:        InvitationPending,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(cfg.Workspaces.InvitationTTL),
+	}
+	res, err := InvitationCollection.InsertOne(ctx, invitation)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to create invitation")
+		return
+	}
+	invitation.ID = res.InsertedID.(primitive.ObjectID)
+	c.JSON(http.StatusCreated, gin.H{"token": token, "invitation": invitation})
+}
+
+func listInvitations(c *gin.Context) {
+	ctx := c.Request.Context()
+	workspace, ok := findWorkspace(c, middleware.WorkspaceOwner)
+	if !ok {
+		return
+	}
+	filter := bson.M{"workspace_id": workspace.ID}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+	cur, err := InvitationCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch invitations")
+		return
+	}
+	invitations := []Invitation{}
+	if err := cur.All(ctx, &invitations); err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to fetch invitations")
+		return
+	}
+	c.JSON(http.StatusOK, invitations)
+}
+
+func revokeInvitation(c *gin.Context) {
+	ctx := c.Request.Context()
+	workspace, ok := findWorkspace(c, middleware.WorkspaceOwner)
+	if !ok {
+		return
+	}
+	objID, err := primitive.ObjectIDFromHex(c.Param("invitation_id"))
+	if err != nil {
+		problem.Abort(c, http.StatusBadRequest, "Invalid ID")
+		return
+	}
+	res, err := InvitationCollection.UpdateOne(ctx,
+		bson.M{"_id": objID, "workspace_id": workspace.ID, "status": InvitationPending},
+		bson.M{"$set": bson.M{"status": InvitationRevoked, "responded_at": time.Now()}},
+	)
+	if err != nil {
+		problem.Abort(c, http.StatusInternalServerError, "Failed to revoke invitation")
+		return
+	}
+	if res.MatchedCount == 0 {
+		problem.Abort(c, http.StatusNotFound, "Invitation not found or no longer pending")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// respondToInvitation accepts or declines the invitation a token belongs to.
+// Only the invited email address may answer it, and only once.
+func respondToInvitation(accept bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var req InvitationResponse
+		if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+			problem.Render(c, problem.New(http.StatusBadRequest, "Token is required").
+				WithCode(problem.CodeValidationFailed).
+				WithField("token", "is required"))
+			return
+		}
+		user, ok := findCurrentUser(c)
+		if !ok {
+			return
+		}
+		var invitation Invitation
+		err := InvitationCollection.FindOne(ctx, bson.M{"token_hash": middleware.HashToken(req.Token)}).Decode(&invitation)
+		if err == mongo.ErrNoDocuments {
+			problem.Abort(c, http.StatusNotFound, "Invitation not found")
+			return
+		}
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Failed to fetch invitation")
+			return
+		}
+		if !strings.EqualFold(invitation.Email, user.Email) {
+			problem.Abort(c, http.StatusForbidden, "This invitation was sent to a different email address")
+			return
+		}
+		now := time.Now()
+		if invitation.Status == InvitationPending && now.After(invitation.ExpiresAt) {
+			problem.Render(c, problem.New(http.StatusConflict, "Invitation has expired").WithCode("invitation_expired"))
+			return
+		}
+		status := InvitationDeclined
+		if accept {
+			status = InvitationAccepted
+		}
+		res, err := InvitationCollection.UpdateOne(ctx,
+			bson.M{"_id": invitation.ID, "status": InvitationPending},
+			bson.M{"$set": bson.M{"status": status, "responded_at": now}},
+		)
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Failed to update invitation")
+			return
+		}
+		if res.MatchedCount == 0 {
+			problem.Render(c, problem.New(http.StatusConflict, "Invitation is no longer pending").WithCode("invitation_closed"))
+			return
+		}
+		if !accept {
+			c.JSON(http.StatusOK, gin.H{"message": "Invitation declined"})
+			return
+		}
+		res, err = WorkspaceCollection.UpdateOne(ctx,
+			bson.M{"_id": invitation.WorkspaceID, "members.user_id": bson.M{"$ne": user.ID}},
+			bson.M{
+				"$push": bson.M{"members": WorkspaceMember{UserID: user.ID, Role: invitation.Role, JoinedAt: now}},
+				"$set":  bson.M{"updated_at": now},
+			},
+		)
+		if err != nil {
+			problem.Abort(c, http.StatusInternalServerError, "Failed to join workspace")
+			return
+		}
+		if res.MatchedCount == 0 {
+			// Either the workspace was deleted after the invitation was sent
+			// or the user joined it some other way.
+			n, err := WorkspaceCollection.CountDocuments(ctx, bson.M{"_id": invitation.WorkspaceID}, options.Count().SetLimit(1))
+			if err != nil {
+				problem.Abort(c, http.StatusInternalServerError, "Failed to join workspace")
+				return
+			}
+			if n == 0 {
+				problem.Render(c, problem.New(http.StatusNotFound, "Workspace not found").WithCode("workspace_not_found"))
+				return
+			}
+			problem.Render(c, problem.New(http.StatusConflict, "Already a member of this workspace").WithCode("already_member"))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Invitation accepted", "workspace_id": invitation.WorkspaceID})
+	}
+}